@@ -0,0 +1,218 @@
+package cmd
+
+// Copyright © 2026 Robert Impey robert.impey@hotmail.co.uk
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/robert-impey/staydeleted/sdlib"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// The config file, $HOME/.staydeleted.yaml by default, holds defaults for
+// the sweep flags and the targets that sweep and daemon work through, e.g.
+//
+//	expiry: 12
+//	logs: /var/log
+//	log-max-age: 30d
+//	log-max-count: 100
+//	log-format: json
+//	delete-mode: trash
+//	exclude: [node_modules, "*.tmp"]
+//	protected: ["**/.git", "~/Documents/tax/**"]
+//	max-delete-targets: 1000
+//	max-delete-percent: 20
+//	targets:
+//	  photos:
+//	    path: /data/photos
+//	    schedule: "30 3 * * *"
+//	    expiry: 24
+//	    exclude: [cache]
+//	    max-delete-mb: 5000
+//
+// Each setting can also be given as an environment variable such as
+// STAYDELETED_EXPIRY or STAYDELETED_DELETE_MODE. Flags on the command line
+// take precedence over both.
+const envPrefix = "STAYDELETED"
+
+// targetConfig is a sweep target in the config file. Settings left out use
+// the top level ones.
+type targetConfig struct {
+	Path       string   `mapstructure:"path"`
+	Schedule   string   `mapstructure:"schedule"`
+	Expiry     *int     `mapstructure:"expiry"`
+	Verbose    *bool    `mapstructure:"verbose"`
+	DeleteMode string   `mapstructure:"delete-mode"`
+	Exclude    []string `mapstructure:"exclude"`
+	Protected  []string `mapstructure:"protected"`
+
+	MaxDeleteTargets *int     `mapstructure:"max-delete-targets"`
+	MaxDeleteMB      *int64   `mapstructure:"max-delete-mb"`
+	MaxDeletePercent *float64 `mapstructure:"max-delete-percent"`
+}
+
+// configTarget is a target from the config file with its options resolved.
+// A target that is verbose when the others aren't has its own logger.
+type configTarget struct {
+	Name     string
+	Path     string
+	Schedule string
+	Options  sdlib.SweepOptions
+}
+
+var Exclude []string
+var Protected []string
+
+func initEnv() {
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	viper.AutomaticEnv()
+}
+
+func addExcludeFlag(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&Exclude, "exclude", nil,
+		"A glob matched against file and directory names that are never swept.")
+}
+
+func addProtectFlag(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&Protected, "protect", nil,
+		"A pattern for paths that are never deleted, e.g. '**/.git' or '~/Documents/tax/**'.")
+}
+
+// bindSweepConfig lets settings in the config file supply flags that
+// weren't given on the command line. It is called just before the command
+// runs so that the keys are bound to the flags of the command being run.
+func bindSweepConfig(cmd *cobra.Command) {
+	viper.BindPFlag("logs", cmd.Flags().Lookup("logs"))
+	viper.BindPFlag("expiry", cmd.Flags().Lookup("expiry"))
+	viper.BindPFlag("verbose", cmd.Flags().Lookup("verbose"))
+	viper.BindPFlag("exclude", cmd.Flags().Lookup("exclude"))
+	viper.BindPFlag("protected", cmd.Flags().Lookup("protect"))
+	viper.BindPFlag("delete-mode", cmd.Flags().Lookup("delete-mode"))
+	viper.BindPFlag("external-locks", cmd.Flags().Lookup("external-lock"))
+	viper.BindPFlag("lock-wait", cmd.Flags().Lookup("lock-wait"))
+	viper.BindPFlag("max-delete-targets", cmd.Flags().Lookup("max-delete-targets"))
+	viper.BindPFlag("max-delete-mb", cmd.Flags().Lookup("max-delete-mb"))
+	viper.BindPFlag("max-delete-percent", cmd.Flags().Lookup("max-delete-percent"))
+}
+
+// deletionLimits reads the deletion limits, which --override-limits turns off.
+func deletionLimits() sdlib.DeletionLimits {
+	if OverrideLimits {
+		return sdlib.DeletionLimits{}
+	}
+	return sdlib.DeletionLimits{
+		MaxTargets: viper.GetInt("max-delete-targets"),
+		MaxBytes:   viper.GetInt64("max-delete-mb") * 1024 * 1024,
+		MaxPercent: viper.GetFloat64("max-delete-percent"),
+	}
+}
+
+// sweepOptions reads the sweep settings, logging to outWriter and errWriter.
+func sweepOptions(outWriter io.Writer, errWriter io.Writer) (sdlib.SweepOptions, error) {
+	deleteMode, err := sdlib.ParseDeleteMode(viper.GetString("delete-mode"))
+	if err != nil {
+		return sdlib.SweepOptions{}, err
+	}
+
+	logger, err := newLogger(outWriter, errWriter, viper.GetBool("verbose"))
+	if err != nil {
+		return sdlib.SweepOptions{}, err
+	}
+
+	return sdlib.SweepOptions{
+		ExpiryMonths:  viper.GetInt("expiry"),
+		Logger:        logger,
+		DryRun:        DryRun,
+		DeleteMode:    deleteMode,
+		Exclude:       viper.GetStringSlice("exclude"),
+		Protected:     viper.GetStringSlice("protected"),
+		ExternalLocks: viper.GetStringSlice("external-locks"),
+		LockWait:      viper.GetDuration("lock-wait"),
+		Limits:        deletionLimits(),
+	}, nil
+}
+
+// configuredTargets reads the targets in the config file, sorted by name,
+// applying each one's settings over opts.
+func configuredTargets(opts sdlib.SweepOptions, outWriter io.Writer, errWriter io.Writer) ([]configTarget, error) {
+	var configs map[string]targetConfig
+	if err := viper.UnmarshalKey("targets", &configs); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	targets := make([]configTarget, 0, len(configs))
+	for _, name := range names {
+		config := configs[name]
+		if len(config.Path) == 0 {
+			return nil, fmt.Errorf("target '%s' has no path", name)
+		}
+
+		targetOpts := opts
+		if config.Expiry != nil {
+			targetOpts.ExpiryMonths = *config.Expiry
+		}
+		if config.Verbose != nil {
+			logger, err := newLogger(outWriter, errWriter, *config.Verbose)
+			if err != nil {
+				return nil, err
+			}
+			targetOpts.Logger = logger
+		}
+		if len(config.DeleteMode) > 0 {
+			deleteMode, err := sdlib.ParseDeleteMode(config.DeleteMode)
+			if err != nil {
+				return nil, fmt.Errorf("target '%s' - %v", name, err)
+			}
+			targetOpts.DeleteMode = deleteMode
+		}
+		if len(config.Exclude) > 0 {
+			targetOpts.Exclude = append(append([]string{}, opts.Exclude...), config.Exclude...)
+		}
+		if len(config.Protected) > 0 {
+			targetOpts.Protected = append(append([]string{}, opts.Protected...), config.Protected...)
+		}
+		if !OverrideLimits {
+			if config.MaxDeleteTargets != nil {
+				targetOpts.Limits.MaxTargets = *config.MaxDeleteTargets
+			}
+			if config.MaxDeleteMB != nil {
+				targetOpts.Limits.MaxBytes = *config.MaxDeleteMB * 1024 * 1024
+			}
+			if config.MaxDeletePercent != nil {
+				targetOpts.Limits.MaxPercent = *config.MaxDeletePercent
+			}
+		}
+
+		targets = append(targets, configTarget{
+			Name:     name,
+			Path:     config.Path,
+			Schedule: config.Schedule,
+			Options:  targetOpts,
+		})
+	}
+
+	return targets, nil
+}
@@ -0,0 +1,116 @@
+package cmd
+
+// Copyright © 2026 Robert Impey robert.impey@hotmail.co.uk
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/robert-impey/staydeleted/sdlib"
+	"github.com/spf13/cobra"
+)
+
+// daemonCmd represents the daemon command
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Sweep the targets in the config file on their schedules",
+	Long: `Run until stopped, sweeping each target in the config file on its schedule.
+A schedule is an interval such as 6h, one of @hourly, @daily, @weekly
+or @monthly, or a cron expression such as "30 3 * * *".`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		bindSweepConfig(cmd)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		err := daemon()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		exitWith(err)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+
+	daemonCmd.Flags().StringVarP(&LogsDir, "logs", "l", "",
+		"The logs directory.")
+	daemonCmd.Flags().IntVarP(&ExpiryMonths, "expiry", "e", 12,
+		"The number of months before SD files expire.")
+	daemonCmd.Flags().BoolVarP(&Verbose, "verbose", "v", false, "Log verbosely, the same as --log-level debug.")
+	addExcludeFlag(daemonCmd)
+	addProtectFlag(daemonCmd)
+	addDeleteModeFlag(daemonCmd)
+	addLockFlags(daemonCmd)
+	addLimitFlags(daemonCmd)
+}
+
+func daemonTargets(opts sdlib.SweepOptions, outWriter io.Writer, errWriter io.Writer) ([]sdlib.DaemonTarget, error) {
+	configs, err := configuredTargets(opts, outWriter, errWriter)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]sdlib.DaemonTarget, 0, len(configs))
+	for _, config := range configs {
+		if len(config.Schedule) == 0 {
+			opts.Logger.Warn("Not sweeping target as it has no schedule", "name", config.Name,
+				sdlib.RootKey, config.Path)
+			continue
+		}
+
+		schedule, err := sdlib.ParseSchedule(config.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("target '%s' - %v", config.Name, err)
+		}
+
+		targets = append(targets, sdlib.DaemonTarget{
+			Name:     config.Name,
+			Path:     config.Path,
+			Schedule: schedule,
+			Options:  config.Options,
+		})
+	}
+
+	return targets, nil
+}
+
+func daemon() error {
+	outWriter, errWriter, closeLogs, err := openLogs()
+	if err != nil {
+		return err
+	}
+	defer closeLogs()
+
+	opts, err := sweepOptions(outWriter, errWriter)
+	if err != nil {
+		return err
+	}
+
+	targets, err := daemonTargets(opts, outWriter, errWriter)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = sdlib.RunDaemon(ctx, targets, opts.Logger)
+	opts.Logger.Info("Stopped")
+	return err
+}
@@ -0,0 +1,104 @@
+package cmd
+
+// Copyright © 2026 Robert Impey robert.impey@hotmail.co.uk
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+import (
+	"errors"
+	"os"
+
+	"github.com/robert-impey/staydeleted/sdlib"
+)
+
+// The exit codes of the commands, so that cron jobs and monitoring can tell
+// a clean run from a broken one.
+const (
+	// ExitSuccess means everything was done.
+	ExitSuccess = 0
+	// ExitPartialFailure means the command ran but some paths failed.
+	ExitPartialFailure = 1
+	// ExitFatal means the command, or one of its directories, couldn't run.
+	ExitFatal = 2
+	// ExitLockHeld means a sweep was skipped because a lock was held.
+	ExitLockHeld = 3
+	// ExitLimitExceeded means a sweep deleted nothing because it would
+	// have broken a deletion limit.
+	ExitLimitExceeded = 4
+)
+
+// exitSeverity orders the exit codes so that the worst outcome of several
+// directories is the one reported.
+var exitSeverity = map[int]int{
+	ExitSuccess:        0,
+	ExitPartialFailure: 1,
+	ExitLockHeld:       2,
+	ExitLimitExceeded:  3,
+	ExitFatal:          4,
+}
+
+// exitCode maps the error a command finished with to its exit code.
+func exitCode(err error) int {
+	if err == nil {
+		return ExitSuccess
+	}
+
+	// Errors from several directories are joined; report the worst.
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		if _, partial := err.(*sdlib.PartialFailureError); !partial {
+			code := ExitSuccess
+			for _, e := range joined.Unwrap() {
+				if c := exitCode(e); exitSeverity[c] > exitSeverity[code] {
+					code = c
+				}
+			}
+			return code
+		}
+	}
+
+	var lockHeld *sdlib.LockHeldError
+	var limit *sdlib.LimitExceededError
+	var partial *sdlib.PartialFailureError
+	switch {
+	case errors.As(err, &lockHeld):
+		return ExitLockHeld
+	case errors.As(err, &limit):
+		return ExitLimitExceeded
+	case errors.As(err, &partial):
+		return ExitPartialFailure
+	}
+	return ExitFatal
+}
+
+// pathsResult is the error for a command given total paths of which
+// failures failed. It is fatal if every path failed.
+func pathsResult(total int, failures []sdlib.PathFailure) error {
+	if len(failures) == 0 {
+		return nil
+	}
+	if len(failures) == total {
+		errs := make([]error, len(failures))
+		for i, failure := range failures {
+			errs[i] = failure.Err
+		}
+		return errors.Join(errs...)
+	}
+	return &sdlib.PartialFailureError{Failures: failures}
+}
+
+// exitWith exits with the code for err, if it isn't a success.
+func exitWith(err error) {
+	if code := exitCode(err); code != ExitSuccess {
+		os.Exit(code)
+	}
+}
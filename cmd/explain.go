@@ -0,0 +1,183 @@
+package cmd
+
+// Copyright © 2026 Robert Impey robert.impey@hotmail.co.uk
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/robert-impey/staydeleted/sdlib"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// explainCmd represents the explain command
+var explainCmd = &cobra.Command{
+	Use:   "explain",
+	Short: "Explain why a file is or isn't going to be deleted",
+	Long: `Find the SD file that governs each path given in the command line args
+and print the mark it holds, when it was made, when it expires
+and what the next sweep would do with the path, taking account of
+the expiry, exclude and protected settings in the config file.
+A path in one of the config file's targets is explained as a sweep
+of that target would see it.`,
+	Args: cobra.MinimumNArgs(1),
+	PreRun: func(cmd *cobra.Command, args []string) {
+		bindSweepConfig(cmd)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		opts := sdlib.SweepOptions{
+			ExpiryMonths: viper.GetInt("expiry"),
+			Exclude:      viper.GetStringSlice("exclude"),
+			Protected:    viper.GetStringSlice("protected"),
+		}
+		targets, err := configuredTargets(opts, os.Stderr, os.Stderr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			exitWith(err)
+			return
+		}
+
+		var failures []sdlib.PathFailure
+		for _, arg := range args {
+			root, targetOpts := explainTarget(arg, targets, opts)
+			explanation, err := sdlib.ExplainPath(arg, root, targetOpts)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				failures = append(failures, sdlib.PathFailure{Path: arg, Err: err})
+				continue
+			}
+			printExplanation(explanation, os.Stdout)
+		}
+		exitWith(pathsResult(len(args), failures))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(explainCmd)
+
+	explainCmd.Flags().IntP("expiry", "e", 12,
+		"The number of months before SD files expire.")
+	addExcludeFlag(explainCmd)
+	addProtectFlag(explainCmd)
+}
+
+// explainTarget finds the innermost configured target that path is in and
+// returns its path and options. A path in no target has no root and opts.
+func explainTarget(path string, targets []configTarget, opts sdlib.SweepOptions) (string, sdlib.SweepOptions) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", opts
+	}
+
+	var root string
+	rootOpts := opts
+	for _, target := range targets {
+		targetPath, err := filepath.Abs(target.Path)
+		if err != nil || len(targetPath) <= len(root) {
+			continue
+		}
+		if absPath == targetPath || strings.HasPrefix(absPath, targetPath+string(filepath.Separator)) {
+			root, rootOpts = targetPath, target.Options
+		}
+	}
+	return root, rootOpts
+}
+
+func printExplanation(explanation *sdlib.Explanation, w io.Writer) {
+	const timeFormat = "2006-01-02 15:04:05"
+
+	fmt.Fprintf(w, "Path:    %s\n", explanation.Path)
+	fmt.Fprintf(w, "SD file: %s", explanation.SdFile)
+	if !explanation.SdFileExists {
+		fmt.Fprint(w, " (none)")
+	}
+	fmt.Fprintln(w)
+
+	if len(explanation.Problem) > 0 {
+		fmt.Fprintf(w, "Problem: the SD file is malformed - %s\n", explanation.Problem)
+	}
+
+	if mark := explanation.Mark; mark != nil {
+		fmt.Fprintf(w, "Action:  %v\n", mark.Action)
+		fmt.Fprintf(w, "Marked:  %s", mark.MarkTime().Format(timeFormat))
+		if mark.Version == sdlib.LegacySdFileVersion {
+			fmt.Fprint(w, " (from the SD file's modification time)")
+		} else {
+			fmt.Fprintf(w, " on %s, clock %d", mark.Replica, mark.Clock)
+		}
+		fmt.Fprintln(w)
+		if !mark.NotBefore.IsZero() {
+			fmt.Fprintf(w, "Due:     %s\n", mark.NotBefore.Format(timeFormat))
+		}
+		fmt.Fprintf(w, "Expires: %s", explanation.ExpiresAt.Format(timeFormat))
+		if !mark.ExpiresAt.IsZero() {
+			fmt.Fprint(w, " (the mark's own expiry)")
+		}
+		fmt.Fprintln(w)
+	}
+
+	if len(explanation.Outcomes) == 0 {
+		fmt.Fprintln(w, "Next sweep: will leave it alone, as no mark governs it.")
+	}
+	for _, outcome := range explanation.Outcomes {
+		fmt.Fprintf(w, "Next sweep: %s\n", describeOutcome(explanation.Path, outcome))
+	}
+	fmt.Fprintln(w)
+}
+
+func describeOutcome(path string, entry sdlib.PlanEntry) string {
+	by := fmt.Sprintf("as instructed by '%s'", entry.SdFile)
+	if len(entry.Pattern) > 0 {
+		by = fmt.Sprintf("as it matches '%s' in '%s'", entry.Pattern, entry.SdFile)
+	}
+
+	switch entry.Kind {
+	case sdlib.TargetDeletion:
+		if entry.Path != path {
+			return fmt.Sprintf("will delete it along with '%s' %s.", entry.Path, by)
+		}
+		return fmt.Sprintf("will delete it %s.", by)
+	case sdlib.ExpiredSdFile:
+		return fmt.Sprintf("will delete the expired SD file '%s' from %s.",
+			entry.Path, entry.MarkedAt.Format("2006-01-02 15:04:05"))
+	case sdlib.MalformedSdFile:
+		return fmt.Sprintf("will delete the malformed SD file '%s' - %s.", entry.Path, entry.Reason)
+	case sdlib.EmptySdFolder:
+		return fmt.Sprintf("will delete the empty SD folder '%s'.", entry.Path)
+	case sdlib.AlreadyDeleted:
+		return fmt.Sprintf("nothing to do, it is already deleted %s.", by)
+	case sdlib.Kept:
+		return fmt.Sprintf("will keep it %s.", by)
+	case sdlib.SupersededSdFile:
+		return fmt.Sprintf("will ignore '%s' as it is %s.", entry.SdFile, entry.Reason)
+	case sdlib.ChangedTarget:
+		return fmt.Sprintf("will not delete it %s, as %s.", by, entry.Reason)
+	case sdlib.Excluded:
+		return fmt.Sprintf("will not delete it %s, as it matches an exclude pattern.", by)
+	case sdlib.HostileSdFile:
+		return fmt.Sprintf("will ignore the hostile SD file '%s' - %s.", entry.SdFile, entry.Reason)
+	case sdlib.UnreadableSdFile:
+		return fmt.Sprintf("will leave the SD file '%s' alone, as it couldn't be read - %s.", entry.Path, entry.Reason)
+	case sdlib.NotYetDue:
+		return fmt.Sprintf("will not delete it yet %s, as it is %s.", by, entry.Reason)
+	case sdlib.Protected:
+		return fmt.Sprintf("will not delete '%s' %s, as %s.", entry.Path, by, entry.Reason)
+	}
+	return entry.Kind.String()
+}
@@ -0,0 +1,189 @@
+package cmd
+
+// Copyright © 2026 Robert Impey robert.impey@hotmail.co.uk
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/robert-impey/staydeleted/sdlib"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var ListAction string
+var ListOlderThan string
+var ListNewerThan string
+var ListExists bool
+var ListMissing bool
+var ListOutput string
+
+// listCmd represents the list command
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the marks under directories",
+	Long: `Walk through the directories given in the command line args
+and print every mark found, with its action, its age,
+whether the marked file currently exists and when the mark expires.`,
+	Args: cobra.MinimumNArgs(1),
+	PreRun: func(cmd *cobra.Command, args []string) {
+		bindSweepConfig(cmd)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		err := list(args, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		exitWith(err)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(listCmd)
+
+	listCmd.Flags().StringVarP(&ListAction, "action", "a", "",
+		"Only list marks with this action, either delete or keep.")
+	listCmd.Flags().StringVar(&ListOlderThan, "older-than", "",
+		"Only list marks older than this age, e.g. 90d or 12h.")
+	listCmd.Flags().StringVar(&ListNewerThan, "newer-than", "",
+		"Only list marks newer than this age, e.g. 90d or 12h.")
+	listCmd.Flags().BoolVar(&ListExists, "exists", false,
+		"Only list marks whose target currently exists.")
+	listCmd.Flags().BoolVar(&ListMissing, "missing", false,
+		"Only list marks whose target doesn't currently exist.")
+	listCmd.Flags().StringVarP(&ListOutput, "output", "o", "table",
+		"The output format, either table or json.")
+	listCmd.Flags().IntP("expiry", "e", 12,
+		"The number of months before SD files expire.")
+}
+
+func list(dirs []string, w io.Writer) error {
+	filter, err := listFilter()
+	if err != nil {
+		return err
+	}
+
+	// Only warnings go to the logger, so that the list itself stays clean.
+	logger, err := newLogger(os.Stderr, os.Stderr, false)
+	if err != nil {
+		return err
+	}
+
+	marks := make([]sdlib.MarkInfo, 0)
+	for _, dir := range dirs {
+		dirMarks, err := sdlib.ListMarks(dir, viper.GetInt("expiry"), logger)
+		if err != nil {
+			return err
+		}
+
+		for _, mark := range dirMarks {
+			if filter(mark) {
+				marks = append(marks, mark)
+			}
+		}
+	}
+
+	switch ListOutput {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(marks)
+	case "table":
+		return printMarksTable(marks, w)
+	}
+	return fmt.Errorf("unknown output format '%s'", ListOutput)
+}
+
+func listFilter() (func(sdlib.MarkInfo) bool, error) {
+	action := sdlib.NoAction
+	if len(ListAction) > 0 {
+		if err := action.UnmarshalText([]byte(ListAction)); err != nil {
+			return nil, err
+		}
+	}
+
+	var olderThan, newerThan time.Duration
+	var err error
+	if len(ListOlderThan) > 0 {
+		if olderThan, err = parseAge(ListOlderThan); err != nil {
+			return nil, err
+		}
+	}
+	if len(ListNewerThan) > 0 {
+		if newerThan, err = parseAge(ListNewerThan); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(mark sdlib.MarkInfo) bool {
+		if action != sdlib.NoAction && mark.Action != action {
+			return false
+		}
+		if olderThan > 0 && mark.Age() <= olderThan {
+			return false
+		}
+		if newerThan > 0 && mark.Age() >= newerThan {
+			return false
+		}
+		if ListExists && !mark.TargetExists {
+			return false
+		}
+		if ListMissing && mark.TargetExists {
+			return false
+		}
+		return true
+	}, nil
+}
+
+// parseAge parses a duration, also accepting a whole number of days such as "90d".
+func parseAge(ageStr string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(ageStr, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("unable to convert %s to an age", ageStr)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(ageStr)
+}
+
+func formatAge(age time.Duration) string {
+	if age >= 24*time.Hour {
+		return fmt.Sprintf("%dd", int(age.Hours()/24))
+	}
+	return age.Truncate(time.Minute).String()
+}
+
+func printMarksTable(marks []sdlib.MarkInfo, w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tACTION\tAGE\tEXISTS\tEXPIRES")
+	for _, mark := range marks {
+		target := mark.Target
+		if mark.Recursive {
+			target += " (recursive)"
+		}
+		fmt.Fprintf(tw, "%s\t%v\t%s\t%t\t%s\n",
+			target, mark.Action, formatAge(mark.Age()), mark.TargetExists,
+			mark.ExpiresAt.Format("2006-01-02"))
+	}
+	return tw.Flush()
+}
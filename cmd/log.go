@@ -0,0 +1,96 @@
+package cmd
+
+// Copyright © 2026 Robert Impey robert.impey@hotmail.co.uk
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/robert-impey/staydeleted/sdlib"
+	"github.com/spf13/viper"
+)
+
+var LogFormat string
+var LogLevel string
+
+// The retention of the logs directory, which is only set in the config file
+// or the environment.
+const (
+	defaultLogMaxAge   = "90d"
+	defaultLogCompress = true
+)
+
+// logRetention reads how long, and how many, log files are kept.
+func logRetention() (sdlib.LogRetention, error) {
+	maxAgeStr := defaultLogMaxAge
+	if viper.IsSet("log-max-age") {
+		maxAgeStr = viper.GetString("log-max-age")
+	}
+	var maxAge time.Duration
+	if len(maxAgeStr) > 0 && maxAgeStr != "0" {
+		var err error
+		maxAge, err = parseAge(maxAgeStr)
+		if err != nil {
+			return sdlib.LogRetention{}, fmt.Errorf("log-max-age - %v", err)
+		}
+	}
+
+	compress := defaultLogCompress
+	if viper.IsSet("log-compress") {
+		compress = viper.GetBool("log-compress")
+	}
+
+	return sdlib.LogRetention{
+		MaxAge:   maxAge,
+		MaxCount: viper.GetInt("log-max-count"),
+		MaxSize:  viper.GetInt64("log-max-size-mb") * 1024 * 1024,
+		Compress: compress,
+	}, nil
+}
+
+// openLogs opens the writers for the logs directory given by --logs, if
+// any. The returned function closes them.
+func openLogs() (io.Writer, io.Writer, func() error, error) {
+	retention, err := logRetention()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return sdlib.GetWriters(viper.GetString("logs"), retention)
+}
+
+// logLevel is the level given by --log-level, lowered to debug by verbose.
+func logLevel(verbose bool) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(viper.GetString("log-level"))); err != nil {
+		return level, fmt.Errorf("unknown log level '%s', expecting debug, info, warn or error",
+			viper.GetString("log-level"))
+	}
+	if verbose && level > slog.LevelDebug {
+		level = slog.LevelDebug
+	}
+	return level, nil
+}
+
+// newLogger creates the logger for a command, writing warnings and errors
+// to errWriter and everything else to outWriter.
+func newLogger(outWriter io.Writer, errWriter io.Writer, verbose bool) (*slog.Logger, error) {
+	level, err := logLevel(verbose)
+	if err != nil {
+		return nil, err
+	}
+	return sdlib.NewLogger(viper.GetString("log-format"), level, outWriter, errWriter)
+}
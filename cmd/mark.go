@@ -18,27 +18,64 @@ import (
 	"fmt"
 	"github.com/robert-impey/staydeleted/sdlib"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var Keep bool
+var Hash bool
+var Pattern string
+var Recursive bool
+var Expires string
+var After string
 
 // markCmd represents the mark command
 var markCmd = &cobra.Command{
 	Use:   "mark",
 	Short: "Mark a file for deletion or keeping",
 	Long: `Files marked for deletion or keeping will be
-taken care of by the sweep command.`,
+taken care of by the sweep command.
+
+With --pattern, the arguments are directories (default is the current
+directory) and every file matching the pattern in them will be
+taken care of, including files created after marking.
+
+Protected paths, from --protect or the config file, can't be marked
+for deletion.
+
+--expires gives the mark its own expiry in place of sweep's --expiry,
+and --after holds off deleting until the time given. Both take a date
+such as 2026-12-31, meaning the end of that day, a time such as
+2026-12-31T09:00:00Z, or a whole number of days, weeks, months or years
+from now such as 90d, 2w, 6mo or 3y.`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		viper.BindPFlag("protected", cmd.Flags().Lookup("protect"))
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		action := sdlib.GetActionForBool(Keep)
 
-		for _, arg := range args {
-			err := sdlib.SetActionForFile(arg, action)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "couldn't set action for file '%s'\n", arg)
-				return
-			}
+		logger, err := newLogger(os.Stdout, os.Stderr, false)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			exitWith(err)
+			return
+		}
+		opts := sdlib.MarkOptions{Hash: Hash, Logger: logger, Protected: viper.GetStringSlice("protected")}
+		if err := markTimes(&opts, time.Now()); err != nil {
+			logger.Error("Unable to mark", sdlib.ErrorKey, err)
+			exitWith(err)
+			return
 		}
+
+		if len(Pattern) > 0 {
+			exitWith(markPattern(args, action, opts))
+			return
+		}
+
+		exitWith(markFiles(args, action, opts))
 	},
 }
 
@@ -54,4 +91,95 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	markCmd.Flags().BoolVarP(&Keep, "keep", "k", false, "Keep this file.")
+	markCmd.Flags().BoolVar(&Hash, "hash", false,
+		"Record a hash of the file's contents to recognise it at sweep time.")
+	markCmd.Flags().StringVarP(&Pattern, "pattern", "p", "",
+		"Mark every file matching this glob pattern, e.g. '*.tmp'.")
+	markCmd.Flags().BoolVarP(&Recursive, "recursive", "r", false,
+		"Apply the pattern to all subdirectories too.")
+	addProtectFlag(markCmd)
+	markCmd.Flags().StringVar(&Expires, "expires", "",
+		"When the mark expires, e.g. 2029-12-31 or 3y (default is sweep's --expiry).")
+	markCmd.Flags().StringVar(&After, "after", "",
+		"Don't delete until then, e.g. 2026-12-31 (the end of that day) or 30d.")
+}
+
+// markTimes reads --expires and --after into opts.
+func markTimes(opts *sdlib.MarkOptions, now time.Time) error {
+	var err error
+	if len(Expires) > 0 {
+		if opts.ExpiresAt, err = parseMarkTime(Expires, now); err != nil {
+			return fmt.Errorf("--expires - %v", err)
+		}
+	}
+	if len(After) > 0 {
+		if opts.NotBefore, err = parseMarkTime(After, now); err != nil {
+			return fmt.Errorf("--after - %v", err)
+		}
+	}
+	return nil
+}
+
+// parseMarkTime parses a date, a time or a period after now. A date is
+// taken to mean the end of that day, so that --after 2026-12-31 doesn't
+// delete anything on the 31st. A period is a whole number of days, weeks,
+// months or years, such as 90d, 2w, 6mo or 3y; shorter units aren't
+// accepted, so that 3m can't be taken for minutes rather than months.
+func parseMarkTime(value string, now time.Time) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t.AddDate(0, 0, 1), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	for _, unit := range []struct {
+		suffix              string
+		years, months, days int
+	}{
+		{"y", 1, 0, 0},
+		{"mo", 0, 1, 0},
+		{"w", 0, 0, 7},
+		{"d", 0, 0, 1},
+	} {
+		if n, found := strings.CutSuffix(value, unit.suffix); found {
+			count, err := strconv.Atoi(n)
+			if err != nil || count < 0 {
+				return time.Time{}, fmt.Errorf("unable to convert %s to a time", value)
+			}
+			return now.AddDate(count*unit.years, count*unit.months, count*unit.days), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf(
+		"unable to convert %s to a time - use a date, or a number of days (d), weeks (w), months (mo) or years (y)", value)
+}
+
+// markFiles marks each of the files, carrying on past any that fail.
+func markFiles(files []string, action sdlib.Action, opts sdlib.MarkOptions) error {
+	var failures []sdlib.PathFailure
+	for _, file := range files {
+		err := sdlib.MarkFile(file, action, opts)
+		if err != nil {
+			opts.Logger.Error("Couldn't set action for file", sdlib.TargetKey, file, sdlib.ErrorKey, err)
+			failures = append(failures, sdlib.PathFailure{Path: file, Err: err})
+		}
+	}
+	return pathsResult(len(files), failures)
+}
+
+func markPattern(dirs []string, action sdlib.Action, opts sdlib.MarkOptions) error {
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+
+	var failures []sdlib.PathFailure
+	for _, dir := range dirs {
+		err := sdlib.MarkPattern(dir, Pattern, Recursive, action, opts)
+		if err != nil {
+			opts.Logger.Error("Couldn't set action for pattern", "pattern", Pattern, "dir", dir, sdlib.ErrorKey, err)
+			failures = append(failures, sdlib.PathFailure{Path: dir, Err: err})
+		}
+	}
+	return pathsResult(len(dirs), failures)
 }
@@ -6,12 +6,14 @@ Copyright © 2022 Robert Impey robert.impey@hotmail.co.uk
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"github.com/robert-impey/staydeleted/sdlib"
 	"os"
 	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 // markFromCmd represents the markFrom command
@@ -19,24 +21,42 @@ var markFromCmd = &cobra.Command{
 	Use:   "markFrom",
 	Short: "Mark all the files in a text file for deletion",
 	Long:  `If many files need to be marked for deletion, a text file can be provided.`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		viper.BindPFlag("protected", cmd.Flags().Lookup("protect"))
+	},
 	Run: func(cmd *cobra.Command, args []string) {
+		logger, err := newLogger(os.Stdout, os.Stderr, false)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			exitWith(err)
+			return
+		}
+		opts := sdlib.MarkOptions{Hash: Hash, Logger: logger, Protected: viper.GetStringSlice("protected")}
+
+		var errs []error
 		for _, arg := range args {
-			err := markFrom(arg)
+			err := markFrom(arg, opts)
 			if err != nil {
-				fmt.Fprint(os.Stderr, err.Error())
+				logger.Error("Unable to mark the files", "file", arg, sdlib.ErrorKey, err)
+				errs = append(errs, err)
 			} else {
-				fmt.Println("Success")
+				logger.Info("Marked the files", "file", arg)
 			}
 		}
+		exitWith(errors.Join(errs...))
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(markFromCmd)
+
+	markFromCmd.Flags().BoolVar(&Hash, "hash", false,
+		"Record a hash of each file's contents to recognise it at sweep time.")
+	addProtectFlag(markFromCmd)
 }
 
-func markFrom(markFromFileName string) error {
-	fmt.Printf("Reading %v\n", markFromFileName)
+func markFrom(markFromFileName string, opts sdlib.MarkOptions) error {
+	opts.Logger.Debug("Reading files to mark", "file", markFromFileName)
 
 	markFromFile, err := os.Open(markFromFileName)
 
@@ -62,14 +82,14 @@ func markFrom(markFromFileName string) error {
 
 	action := sdlib.Delete
 
+	var failures []sdlib.PathFailure
 	for _, fileToMark := range filesToMark {
-		err := sdlib.SetActionForFile(fileToMark, action)
+		err := sdlib.MarkFile(fileToMark, action, opts)
 		if err != nil {
-			fmt.Fprint(os.Stderr, err.Error())
-		} else {
-			fmt.Printf("Marked %v as %v\n", fileToMark, action)
+			opts.Logger.Error("Couldn't set action for file", sdlib.TargetKey, fileToMark, sdlib.ErrorKey, err)
+			failures = append(failures, sdlib.PathFailure{Path: fileToMark, Err: err})
 		}
 	}
 
-	return nil
+	return pathsResult(len(filesToMark), failures)
 }
@@ -0,0 +1,54 @@
+package cmd
+
+// Copyright © 2026 Robert Impey robert.impey@hotmail.co.uk
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/robert-impey/staydeleted/sdlib"
+	"github.com/spf13/cobra"
+)
+
+var ResolveDryRun bool
+
+// resolveCmd represents the resolve command
+var resolveCmd = &cobra.Command{
+	Use:   "resolve",
+	Short: "Rewrite stale SD files to the newest mark",
+	Long: `The arguments to this command are replicas of the same directory,
+for example a folder and its copy on a backup disk.
+Wherever the replicas, or conflicting copies left by a sync tool,
+disagree about whether a file should be kept or deleted, the
+stale SD files are rewritten to match the newest mark.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, err := newLogger(os.Stdout, os.Stderr, false)
+		if err == nil {
+			err = sdlib.ResolveConflicts(args, ResolveDryRun, logger)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		exitWith(err)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(resolveCmd)
+
+	resolveCmd.Flags().BoolVarP(&ResolveDryRun, "dry-run", "n", false,
+		"Print the SD files that would be rewritten without changing them.")
+}
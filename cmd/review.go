@@ -0,0 +1,108 @@
+package cmd
+
+// Copyright © 2026 Robert Impey robert.impey@hotmail.co.uk
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/robert-impey/staydeleted/sdlib"
+)
+
+var Interactive bool
+
+// reviewer asks the user about each target a sweep would delete.
+type reviewer struct {
+	in     *bufio.Reader
+	out    io.Writer
+	logger *slog.Logger
+	// approveAll and skipAll are set once the user answers for all the
+	// remaining targets.
+	approveAll, skipAll bool
+}
+
+func newReviewer(in io.Reader, out io.Writer, logger *slog.Logger) *reviewer {
+	return &reviewer{in: bufio.NewReader(in), out: out, logger: logger}
+}
+
+// review describes entry and reports whether the user approves deleting
+// it. Choosing keep marks the target to be kept. If the input ends, the
+// rest of the targets are skipped.
+func (r *reviewer) review(entry sdlib.PlanEntry) bool {
+	if r.approveAll {
+		return true
+	}
+	if r.skipAll {
+		return false
+	}
+
+	fmt.Fprintf(r.out, "\nDelete %s?\n", entry.Path)
+	fmt.Fprintf(r.out, "  Size:    %s\n", formatSize(sdlib.PathSize(entry.Path)))
+	fmt.Fprintf(r.out, "  Marked:  %s ago, at %s\n", formatAge(time.Since(entry.MarkedAt)),
+		entry.MarkedAt.Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(r.out, "  SD file: %s\n", entry.SdFile)
+	if len(entry.Pattern) > 0 {
+		fmt.Fprintf(r.out, "  Pattern: %s\n", entry.Pattern)
+	}
+
+	for {
+		fmt.Fprint(r.out, "[y]es, [n]o, [k]eep, [a]ll, [q]uit? ")
+		answer, err := r.in.ReadString('\n')
+		if err != nil && len(answer) == 0 {
+			fmt.Fprintln(r.out)
+			r.skipAll = true
+			return false
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		case "k", "keep":
+			if err := sdlib.MarkFile(entry.Path, sdlib.Keep, sdlib.MarkOptions{Logger: r.logger}); err != nil {
+				r.logger.Error("Couldn't mark the target to be kept", sdlib.TargetKey, entry.Path,
+					sdlib.ErrorKey, err)
+			}
+			return false
+		case "a", "all":
+			r.approveAll = true
+			return true
+		case "q", "quit":
+			r.skipAll = true
+			return false
+		}
+		fmt.Fprintln(r.out, "Please answer y to delete, n to skip, k to keep, a to delete all the rest or q to skip all the rest.")
+	}
+}
+
+// formatSize formats a number of bytes for people to read.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -19,6 +19,7 @@ import (
 	"os"
 
 	"github.com/mitchellh/go-homedir"
+	"github.com/robert-impey/staydeleted/sdlib"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -40,7 +41,7 @@ var rootCmd = &cobra.Command{
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+		os.Exit(ExitFatal)
 	}
 }
 
@@ -51,6 +52,9 @@ func init() {
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.staydeleted.yaml)")
+	rootCmd.PersistentFlags().StringVar(&LogFormat, "log-format", "text", "The format of log records, either text or json.")
+	rootCmd.PersistentFlags().StringVar(&LogLevel, "log-level", "info",
+		"The least severe log records to write: debug, info, warn or error.")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -66,8 +70,8 @@ func initConfig() {
 		// Find home directory.
 		home, err := homedir.Dir()
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(ExitFatal)
 		}
 
 		// Search config in home directory with name ".staydeleted" (without extension).
@@ -75,10 +79,17 @@ func initConfig() {
 		viper.SetConfigName(".staydeleted")
 	}
 
-	viper.AutomaticEnv() // read in environment variables that match
+	initEnv() // read in STAYDELETED_* environment variables
+	viper.BindPFlag("log-format", rootCmd.PersistentFlags().Lookup("log-format"))
+	viper.BindPFlag("log-level", rootCmd.PersistentFlags().Lookup("log-level"))
 
-	// If a config file is found, read it in.
+	// If a config file is found, read it in. This goes to stderr so that
+	// it doesn't get mixed up with reports and listings on stdout.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
+	}
+
+	if replicaID := viper.GetString("replica-id"); len(replicaID) > 0 {
+		sdlib.ReplicaID = replicaID
 	}
 }
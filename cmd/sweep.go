@@ -15,9 +15,11 @@ package cmd
 // limitations under the License.
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"time"
 
 	"github.com/robert-impey/staydeleted/sdlib"
 
@@ -28,6 +30,16 @@ var LogsDir string
 
 var ExpiryMonths int
 var Verbose bool
+var DryRun bool
+var ReportFormat string
+var ReportFile string
+var DeleteMode string
+var ExternalLocks []string
+var LockWait time.Duration
+var MaxDeleteTargets int
+var MaxDeleteMB int64
+var MaxDeletePercent float64
+var OverrideLimits bool
 
 // sweepCmd represents the sweep command
 var sweepCmd = &cobra.Command{
@@ -35,9 +47,17 @@ var sweepCmd = &cobra.Command{
 	Short: "Sweep directories of files marked for deletion.",
 	Long: `Walk through the directories given in the command line args
 looking for files that have been marked for deletion.
+With no args, sweep each target in the config file.
+
+With --interactive, each directory is planned in full and then each
+target it would delete is shown for you to approve, skip, or mark
+to be kept. Skipped targets are left for the next sweep.
 `,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		bindSweepConfig(cmd)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
-		sweep(args)
+		exitWith(sweep(args))
 	},
 }
 
@@ -47,36 +67,172 @@ func init() {
 		"The logs directory.")
 	sweepCmd.Flags().IntVarP(&ExpiryMonths, "expiry", "e", 12,
 		"The number of months before SD files expire.")
-	sweepCmd.Flags().BoolVarP(&Verbose, "verbose", "v", false, "Print verbosely.")
+	sweepCmd.Flags().BoolVarP(&Verbose, "verbose", "v", false, "Log verbosely, the same as --log-level debug.")
+	sweepCmd.Flags().BoolVarP(&DryRun, "dry-run", "n", false,
+		"Print the delete list without deleting anything.")
+	sweepCmd.Flags().BoolVarP(&Interactive, "interactive", "i", false,
+		"Review each target before it is deleted.")
+	addReportFlags(sweepCmd)
+	addExcludeFlag(sweepCmd)
+	addProtectFlag(sweepCmd)
+	addDeleteModeFlag(sweepCmd)
+	addLockFlags(sweepCmd)
+	addLimitFlags(sweepCmd)
+	addOverrideLimitsFlag(sweepCmd)
+}
+
+func addDeleteModeFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&DeleteMode, "delete-mode", "remove",
+		"How to delete marked files, either remove or trash.")
 }
 
-func sweep(paths []string) {
-	outWriter, errWriter, err := sdlib.GetWriters(LogsDir)
+func addLockFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&ExternalLocks, "external-lock", nil,
+		"A lock file, such as a sync script's, that must not exist for the sweep to run.")
+	cmd.Flags().DurationVar(&LockWait, "lock-wait", 0,
+		"How long to wait for held locks before skipping, e.g. 10m.")
+}
+
+func addLimitFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&MaxDeleteTargets, "max-delete-targets", 0,
+		"The most targets one sweep of a directory may delete, 0 for no limit.")
+	cmd.Flags().Int64Var(&MaxDeleteMB, "max-delete-mb", 0,
+		"The most megabytes one sweep of a directory may delete, 0 for no limit.")
+	cmd.Flags().Float64Var(&MaxDeletePercent, "max-delete-percent", 0,
+		"The largest percentage of the files and folders in any one folder one sweep may delete, 0 for no limit.")
+}
+
+func addOverrideLimitsFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&OverrideLimits, "override-limits", false,
+		"Sweep even if the deletion limits would be exceeded.")
+}
+
+func addReportFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&ReportFormat, "report", "",
+		"Write a machine-readable report, either json or jsonl.")
+	cmd.Flags().StringVar(&ReportFile, "report-file", "",
+		"The file to write the report to (default is stdout).")
+}
+
+// openReporter creates the reporter asked for on the command line, if any.
+// When the report goes to stdout, human-readable output moves to stderr so
+// that the two don't mix.
+func openReporter(outWriter io.Writer) (sdlib.Reporter, io.Writer, func(), error) {
+	if len(ReportFormat) == 0 {
+		return nil, outWriter, func() {}, nil
+	}
+
+	var reportWriter io.Writer = os.Stdout
+	var reportFile *os.File
+	if len(ReportFile) > 0 {
+		var err error
+		reportFile, err = os.Create(ReportFile)
+		if err != nil {
+			return nil, outWriter, nil, err
+		}
+		reportWriter = reportFile
+	} else if outWriter == os.Stdout {
+		outWriter = os.Stderr
+	}
+
+	reporter, err := sdlib.NewReporter(ReportFormat, reportWriter)
+	if err != nil {
+		if reportFile != nil {
+			reportFile.Close()
+		}
+		return nil, outWriter, nil, err
+	}
+
+	closeReporter := func() {
+		if err := reporter.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		if reportFile != nil {
+			reportFile.Close()
+		}
+	}
+
+	return reporter, outWriter, closeReporter, nil
+}
+
+func sweep(paths []string) error {
+	outWriter, errWriter, closeLogs, err := openLogs()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return err
+	}
+	defer closeLogs()
+
+	reporter, outWriter, closeReporter, err := openReporter(outWriter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return err
+	}
+	defer closeReporter()
+
+	opts, err := sweepOptions(outWriter, errWriter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return err
+	}
+	opts.Reporter = reporter
+	if Interactive {
+		opts.Review = newReviewer(os.Stdin, os.Stderr, opts.Logger).review
+	}
+
+	if len(paths) > 0 {
+		err = sweepPaths(paths, opts)
+	} else {
+		err = sweepTargets(opts, outWriter, errWriter)
 	}
 
-	sweepPaths(paths, outWriter, errWriter)
+	if err != nil {
+		opts.Logger.Error("Finished with errors", sdlib.ErrorKey, err)
+	}
+	return err
+}
+
+// sweepTargets sweeps every target in the config file.
+func sweepTargets(opts sdlib.SweepOptions, outWriter io.Writer, errWriter io.Writer) error {
+	targets, err := configuredTargets(opts, outWriter, errWriter)
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		return errors.New("no directories given and no targets in the config file")
+	}
+
+	var errs []error
+	for _, target := range targets {
+		target.Options.Logger = target.Options.Logger.With("name", target.Name)
+		target.Options.Logger.Debug("Sweeping target")
+		errs = append(errs, sweepPaths([]string{target.Path}, target.Options))
+	}
+	return errors.Join(errs...)
 }
 
-func sweepPaths(paths []string, outWriter io.Writer, errWriter io.Writer) {
+// sweepPaths sweeps each of the directories, returning the errors of the
+// ones that failed. SweepDirectory logs its own errors as they happen.
+func sweepPaths(paths []string, opts sdlib.SweepOptions) error {
+	var errs []error
 	for _, path := range paths {
 		stat, err := os.Stat(path)
 		if err != nil {
-			fmt.Fprintf(errWriter, "%v\n", err)
+			opts.Logger.Error("Unable to sweep", sdlib.RootKey, path, sdlib.ErrorKey, err)
+			errs = append(errs, err)
 			continue
 		}
 
-		if stat.IsDir() {
-			err := sdlib.SweepDirectory(path, ExpiryMonths, outWriter, errWriter, Verbose)
-			if err != nil {
-				fmt.Fprintf(errWriter, "%v\n", err)
-			}
-		} else {
-			fmt.Fprintf(errWriter, "%v\n is not a directory!", path)
+		if !stat.IsDir() {
+			err := fmt.Errorf("'%v' is not a directory", path)
+			opts.Logger.Error("Unable to sweep", sdlib.RootKey, path, sdlib.ErrorKey, err)
+			errs = append(errs, err)
+			continue
 		}
-		if err != nil {
-			fmt.Fprintf(errWriter, "%v\n", err)
+
+		if err := sdlib.SweepDirectory(path, opts); err != nil {
+			errs = append(errs, err)
 		}
 	}
+	return errors.Join(errs...)
 }
@@ -15,14 +15,16 @@ package cmd
 // limitations under the License.
 
 import (
+	"errors"
 	"fmt"
-	"io"
 	"os"
 
 	"github.com/robert-impey/staydeleted/sdlib"
 	"github.com/spf13/cobra"
 )
 
+var Jobs int
+
 // sweepFromCmd represents the sweepFrom command
 var sweepFromCmd = &cobra.Command{
 	Use:   "sweepFrom",
@@ -30,8 +32,11 @@ var sweepFromCmd = &cobra.Command{
 	Long: `The arguments to this command should be text files with
 	one directory per line.
 	Each directory will be swept.`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		bindSweepConfig(cmd)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
-		sweepFrom(args)
+		exitWith(sweepFrom(args))
 	},
 }
 
@@ -42,40 +47,74 @@ func init() {
 		"The logs directory.")
 	sweepFromCmd.Flags().IntVarP(&ExpiryMonths, "expiry", "e", 12,
 		"The number of months before SD files expire.")
-	sweepFromCmd.Flags().BoolVarP(&Verbose, "verbose", "v", false, "Print verbosely.")
+	sweepFromCmd.Flags().BoolVarP(&Verbose, "verbose", "v", false, "Log verbosely, the same as --log-level debug.")
+	sweepFromCmd.Flags().BoolVarP(&DryRun, "dry-run", "n", false,
+		"Print the delete list without deleting anything.")
+	addReportFlags(sweepFromCmd)
+	addExcludeFlag(sweepFromCmd)
+	addProtectFlag(sweepFromCmd)
+	addDeleteModeFlag(sweepFromCmd)
+	addLockFlags(sweepFromCmd)
+	addLimitFlags(sweepFromCmd)
+	addOverrideLimitsFlag(sweepFromCmd)
+	sweepFromCmd.Flags().IntVarP(&Jobs, "jobs", "j", 1,
+		"The number of directories to sweep at the same time.")
 
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// sweepFromCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 }
 
-func sweepFrom(paths []string) {
-	outWriter, errWriter, err := sdlib.GetWriters(LogsDir)
+func sweepFrom(paths []string) error {
+	outWriter, errWriter, closeLogs, err := openLogs()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return err
+	}
+	defer closeLogs()
+
+	reporter, outWriter, closeReporter, err := openReporter(outWriter)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return err
 	}
+	defer closeReporter()
 
-	sweepFromPaths(paths, outWriter, errWriter)
+	opts, err := sweepOptions(outWriter, errWriter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return err
+	}
+
+	opts.Reporter = reporter
+	opts.Jobs = Jobs
+	err = sweepFromPaths(paths, opts)
+	if err != nil {
+		opts.Logger.Error("Finished with errors", sdlib.ErrorKey, err)
+	}
+	return err
 }
 
-func sweepFromPaths(paths []string, outWriter io.Writer, errWriter io.Writer) {
+func sweepFromPaths(paths []string, opts sdlib.SweepOptions) error {
+	var errs []error
 	for _, path := range paths {
 		stat, err := os.Stat(path)
 		if err != nil {
-			fmt.Fprintf(errWriter, "%v\n", err)
+			opts.Logger.Error("Unable to sweep from file", "file", path, sdlib.ErrorKey, err)
+			errs = append(errs, err)
 			continue
 		}
 
 		if stat.IsDir() {
-			fmt.Fprintf(errWriter, "%v\n is a directory!", path)
-		} else {
-			err := sdlib.SweepFrom(path, ExpiryMonths, outWriter, errWriter, Verbose)
-			if err != nil {
-				fmt.Fprintf(errWriter, "%v\n", err)
-			}
+			err := fmt.Errorf("'%v' is a directory", path)
+			opts.Logger.Error("Unable to sweep from file", "file", path, sdlib.ErrorKey, err)
+			errs = append(errs, err)
+			continue
 		}
-		if err != nil {
-			fmt.Fprintf(errWriter, "%v\n", err)
+
+		if err := sdlib.SweepFrom(path, opts); err != nil {
+			errs = append(errs, err)
 		}
 	}
+	return errors.Join(errs...)
 }
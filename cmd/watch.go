@@ -0,0 +1,79 @@
+package cmd
+
+// Copyright © 2026 Robert Impey robert.impey@hotmail.co.uk
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/robert-impey/staydeleted/sdlib"
+	"github.com/spf13/cobra"
+)
+
+var WatchDebounce time.Duration
+
+// watchCmd represents the watch command
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Mark files for deletion as they are deleted",
+	Long: `Watch the directories given in the command line args and
+mark each file that is deleted or moved out of them for deletion,
+so that it isn't restored from a backup. Files deleted by a sweep
+are not marked again.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		logger, err := newLogger(os.Stdout, os.Stderr, false)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			exitWith(err)
+			return
+		}
+		opts := sdlib.WatchOptions{Debounce: WatchDebounce, Logger: logger}
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		var failures []sdlib.PathFailure
+		for _, arg := range args {
+			wg.Add(1)
+			go func(dir string) {
+				defer wg.Done()
+				err := sdlib.Watch(ctx, dir, opts)
+				if err != nil {
+					logger.Error("Unable to watch", sdlib.RootKey, dir, sdlib.ErrorKey, err)
+					mu.Lock()
+					failures = append(failures, sdlib.PathFailure{Path: dir, Err: err})
+					mu.Unlock()
+				}
+			}(arg)
+		}
+		wg.Wait()
+		exitWith(pathsResult(len(args), failures))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+
+	watchCmd.Flags().DurationVar(&WatchDebounce, "debounce", sdlib.DefaultWatchDebounce,
+		"How long a file must stay deleted before it is marked.")
+}
@@ -0,0 +1,135 @@
+package sdlib
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Supersedes reports whether the mark in a should win over the mark in b
+// when both apply to the same file. The higher logical clock wins; marks
+// made without seeing each other are ordered by mark time, then by replica
+// so that every replica picks the same winner. Keep wins a complete tie as
+// it is the safer choice.
+func (a ActionForFile) Supersedes(b ActionForFile) bool {
+	if a.Clock != b.Clock {
+		return a.Clock > b.Clock
+	}
+	if aTime, bTime := a.MarkTime(), b.MarkTime(); !aTime.Equal(bTime) {
+		return aTime.After(bTime)
+	}
+	if a.Replica != b.Replica {
+		return a.Replica > b.Replica
+	}
+	return a.Action == Keep && b.Action != Keep
+}
+
+// agrees reports whether two marks carry the same intent, so that neither
+// needs rewriting.
+func (a ActionForFile) agrees(b ActionForFile) bool {
+	return a.Action == b.Action && a.Clock == b.Clock && a.Replica == b.Replica &&
+		a.MarkTime().Equal(b.MarkTime())
+}
+
+// groupByKey groups marks by key, keeping the order in which keys were
+// first seen, with the winning mark first in each group.
+func groupByKey(marks []ActionForFile, key func(ActionForFile) string) [][]ActionForFile {
+	indexes := make(map[string]int)
+	groups := make([][]ActionForFile, 0, len(marks))
+	for _, mark := range marks {
+		k := key(mark)
+		i, found := indexes[k]
+		if !found {
+			i = len(groups)
+			indexes[k] = i
+			groups = append(groups, make([]ActionForFile, 0, 1))
+		}
+		groups[i] = append(groups[i], mark)
+	}
+
+	for _, group := range groups {
+		sort.SliceStable(group, func(i, j int) bool {
+			return group[i].Supersedes(group[j])
+		})
+	}
+
+	return groups
+}
+
+// ResolveConflicts finds SD files under the given roots that disagree about
+// the same file and rewrites the stale ones with the winning mark. The roots
+// are treated as replicas of each other, so an SD file in one root is
+// compared with the SD file at the same relative path in the others, as
+// well as with any conflicting copies alongside it left by a sync tool.
+func ResolveConflicts(roots []string, dryRun bool, logger *slog.Logger) error {
+	logger = loggerOrDefault(logger)
+
+	marks := make([]ActionForFile, 0)
+	relFiles := make(map[string]string)
+	for _, root := range roots {
+		absRoot, err := filepath.Abs(root)
+		if err != nil {
+			logger.Error("Unable to resolve conflicts", RootKey, root, ErrorKey, err)
+			return err
+		}
+
+		err = readMarks(absRoot, logger, func(mark ActionForFile) error {
+			relFile, err := filepath.Rel(absRoot, mark.File)
+			if err != nil {
+				return err
+			}
+			relFiles[mark.SdFile] = relFile
+			marks = append(marks, mark)
+			return nil
+		})
+		if err != nil {
+			logger.Error("Unable to resolve conflicts", RootKey, absRoot, ErrorKey, err)
+			return err
+		}
+	}
+
+	groups := groupByKey(marks, func(mark ActionForFile) string {
+		return relFiles[mark.SdFile]
+	})
+
+	var rewriteErr error
+	for _, group := range groups {
+		winner := group[0]
+		for _, stale := range group[1:] {
+			if stale.agrees(winner) {
+				continue
+			}
+
+			attrs := []any{SdFileKey, stale.SdFile, ActionKey, winner.Action, "winning_sd_file", winner.SdFile}
+			if dryRun {
+				logger.Info("Would rewrite", attrs...)
+				continue
+			}
+
+			logger.Info("Rewriting", attrs...)
+			if err := rewriteSdFile(stale.SdFile, winner); err != nil {
+				logger.Error("Unable to rewrite", append(attrs, ErrorKey, err)...)
+				rewriteErr = err
+			}
+		}
+	}
+
+	return rewriteErr
+}
+
+func rewriteSdFile(sdFileName string, winner ActionForFile) error {
+	record := winner.SdRecord
+	record.MarkedAt = winner.MarkTime()
+
+	sdFile, err := os.Create(sdFileName)
+	if err != nil {
+		return err
+	}
+
+	err = writeSdFile(sdFile, record)
+	if closeErr := sdFile.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
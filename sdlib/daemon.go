@@ -0,0 +1,66 @@
+package sdlib
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// DaemonTarget is a directory that RunDaemon sweeps on a schedule.
+type DaemonTarget struct {
+	Name     string
+	Path     string
+	Schedule Schedule
+	Options  SweepOptions
+}
+
+// RunDaemon sweeps each target on its schedule until ctx is done. A sweep
+// in progress then stops after the deletion it is making. The next run of
+// a target is scheduled when its previous run finishes, so runs of a
+// target never overlap. Each target's records are logged with its name, to
+// the logger in its options or else to logger.
+func RunDaemon(ctx context.Context, targets []DaemonTarget, logger *slog.Logger) error {
+	if len(targets) == 0 {
+		return fmt.Errorf("no targets to sweep")
+	}
+
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target DaemonTarget) {
+			defer wg.Done()
+
+			if target.Options.Logger == nil {
+				target.Options.Logger = loggerOrDefault(logger)
+			}
+			target.Options.Logger = target.Options.Logger.With("name", target.Name)
+			targetLogger := target.Options.Logger.With(RootKey, target.Path)
+
+			for {
+				next := target.Schedule.Next(time.Now())
+				if next.IsZero() {
+					targetLogger.Warn("Target will never be swept again by its schedule")
+					return
+				}
+
+				targetLogger.Info("Next sweep scheduled", "at", next)
+
+				timer := time.NewTimer(time.Until(next))
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				case <-timer.C:
+				}
+
+				targetLogger.Info("Starting scheduled sweep")
+				SweepDirectoryContext(ctx, target.Path, target.Options)
+			}
+		}(target)
+	}
+	wg.Wait()
+
+	return nil
+}
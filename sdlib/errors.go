@@ -0,0 +1,43 @@
+package sdlib
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PathFailure is a path that couldn't be dealt with and the reason.
+type PathFailure struct {
+	Path string
+	// SdFile is the SD file that asked for the path to be deleted, if any.
+	SdFile string
+	Err    error
+}
+
+// PartialFailureError is returned when a sweep or mark ran to the end but
+// some of the paths it should have dealt with failed.
+type PartialFailureError struct {
+	// Root is the directory swept, if any.
+	Root     string
+	Failures []PathFailure
+}
+
+func (e *PartialFailureError) Error() string {
+	var b strings.Builder
+	if len(e.Root) > 0 {
+		fmt.Fprintf(&b, "%d path(s) under '%s' failed", len(e.Failures), e.Root)
+	} else {
+		fmt.Fprintf(&b, "%d path(s) failed", len(e.Failures))
+	}
+	for _, failure := range e.Failures {
+		fmt.Fprintf(&b, "\n'%s' - %v", failure.Path, failure.Err)
+	}
+	return b.String()
+}
+
+func (e *PartialFailureError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, failure := range e.Failures {
+		errs[i] = failure.Err
+	}
+	return errs
+}
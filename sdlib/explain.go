@@ -0,0 +1,115 @@
+package sdlib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Explanation describes the marks that govern a path and what the next
+// sweep would do with it.
+type Explanation struct {
+	Path string
+	// SdFile is where the path's own mark is, whether or not it exists.
+	SdFile       string
+	SdFileExists bool
+	// Mark is the path's own mark, if it exists and could be read.
+	Mark *ActionForFile
+	// Problem is why the path's own SD file couldn't be read.
+	Problem   string
+	ExpiresAt time.Time
+	// Outcomes are the entries the next sweep would plan for the path, its
+	// SD file, or a folder containing it. They may come from pattern marks
+	// in the containing folder or its ancestors as well as the path's own mark.
+	Outcomes []PlanEntry
+}
+
+// ExplainPath finds the marks that govern path and plans their SD folders
+// to show what the next sweep would do with it, given the expiry, exclude
+// and protected patterns of opts. root is the root the sweep would start
+// from, if known. It is protected, and only the SD folders the sweep would
+// reach from it are planned.
+func ExplainPath(path, root string, opts SweepOptions) (*Explanation, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(root) > 0 {
+		if root, err = filepath.Abs(root); err != nil {
+			return nil, err
+		}
+	}
+
+	sdFile, err := GetSdFile(absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	explanation := &Explanation{Path: absPath, SdFile: sdFile, Outcomes: make([]PlanEntry, 0)}
+	if _, err := os.Stat(sdFile); err == nil {
+		explanation.SdFileExists = true
+
+		mark, err := GetActionForFile(sdFile, filepath.Dir(absPath))
+		if err != nil {
+			explanation.Problem = err.Error()
+		} else {
+			explanation.Mark = &mark
+			explanation.ExpiresAt = mark.ExpiryTime(opts.ExpiryMonths)
+		}
+	}
+
+	planner := newPlanner(root, opts)
+	for dir := filepath.Dir(absPath); ; {
+		if len(root) > 0 && dir != root && !isWithin(dir, root) {
+			break
+		}
+
+		sdFolder := filepath.Join(dir, SdFolderName)
+		if info, err := os.Stat(sdFolder); err == nil && info.IsDir() && !excludedBelow(dir, root, opts.Exclude) {
+			entries, err := planner.planSdFolder(sdFolder)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, entry := range entries {
+				if entry.Path == absPath || entry.Path == sdFile ||
+					(entry.Kind.Deletes() && isWithin(absPath, entry.Path)) {
+					explanation.Outcomes = append(explanation.Outcomes, entry)
+				}
+			}
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	return explanation, nil
+}
+
+// excludedBelow reports whether a sweep of root skips dir, as dir or one of
+// the folders between it and root is excluded. Without a root, nothing is.
+func excludedBelow(dir, root string, exclude []string) bool {
+	if len(root) == 0 {
+		return false
+	}
+	for ; isWithin(dir, root); dir = filepath.Dir(dir) {
+		if isExcluded(dir, exclude) {
+			return true
+		}
+	}
+	return false
+}
+
+// isWithin reports whether path is inside the folder dir. dir may be the
+// root of a volume, such as / or E:\.
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == "." {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
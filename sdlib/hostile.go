@@ -0,0 +1,50 @@
+package sdlib
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ErrHostileSdFile is wrapped by the errors for SD files that name a target
+// outside their folder. SD files can arrive from any replica, so these are
+// reported and never acted on, not even to delete them as malformed.
+var ErrHostileSdFile = errors.New("hostile SD file")
+
+// validateName checks that the name in an SD file is a single path
+// component, so that the target is inside the SD file's folder.
+func validateName(name string) error {
+	if len(name) == 0 || name == "." || name == ".." ||
+		strings.ContainsAny(name, "/\\\x00") || filepath.IsAbs(name) || len(filepath.VolumeName(name)) > 0 {
+		return fmt.Errorf("%w - '%s' is not a single path component", ErrHostileSdFile, name)
+	}
+	return nil
+}
+
+// escapesFolder explains why target can't be trusted to be inside folder,
+// or returns "" if it can. The target itself may be a symlink, as removing
+// a symlink doesn't touch what it points to, but the folders between folder
+// and the target may not be.
+func escapesFolder(target, folder string) string {
+	if !isWithin(target, folder) {
+		return fmt.Sprintf("'%s' is not inside '%s'", target, folder)
+	}
+
+	realFolder, err := filepath.EvalSymlinks(folder)
+	if err != nil {
+		return err.Error()
+	}
+	realParent, err := filepath.EvalSymlinks(filepath.Dir(target))
+	if err != nil {
+		return err.Error()
+	}
+
+	relParent, _ := filepath.Rel(folder, filepath.Dir(target))
+	relRealParent, err := filepath.Rel(realFolder, realParent)
+	if err != nil || relParent != relRealParent {
+		return fmt.Sprintf("'%s' resolves through a symlink to '%s'", target,
+			filepath.Join(realParent, filepath.Base(target)))
+	}
+	return ""
+}
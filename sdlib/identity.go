@@ -0,0 +1,110 @@
+package sdlib
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// TargetIdentity records what a file marked for deletion looked like when
+// it was marked, so that a different file later created with the same name
+// is not deleted in its place.
+type TargetIdentity struct {
+	IsDir   bool
+	Size    int64
+	ModTime time.Time
+	// SHA256 is the hex encoded hash of the file's contents, if recorded.
+	SHA256 string
+}
+
+// GetTargetIdentity describes the file at path, hashing its contents if
+// withHash is set. Directories are only recorded as being directories, as
+// their size and modification time change with their contents.
+func GetTargetIdentity(path string, withHash bool) (*TargetIdentity, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return &TargetIdentity{IsDir: true}, nil
+	}
+
+	identity := &TargetIdentity{Size: info.Size(), ModTime: info.ModTime()}
+	if withHash && info.Mode().IsRegular() {
+		identity.SHA256, err = hashFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return identity, nil
+}
+
+// Mismatch compares the file at path with the recorded identity and returns
+// why it doesn't match, or an empty string if it does. Modification times
+// only need to match to within modTimeTolerance. When a hash was recorded
+// the size and contents decide, so that copies restored by a sync tool
+// that doesn't preserve modification times are still recognised.
+func (id *TargetIdentity) Mismatch(path string) (string, error) {
+	current, err := GetTargetIdentity(path, len(id.SHA256) > 0)
+	if err != nil {
+		return "", err
+	}
+
+	if id.IsDir != current.IsDir {
+		if id.IsDir {
+			return "it was a directory when marked", nil
+		}
+		return "it was a file when marked", nil
+	}
+	if id.IsDir {
+		return "", nil
+	}
+
+	if id.Size != current.Size {
+		return fmt.Sprintf("its size was %d bytes when marked, now %d", id.Size, current.Size), nil
+	}
+
+	if len(id.SHA256) > 0 {
+		if id.SHA256 != current.SHA256 {
+			return "its contents have changed since it was marked", nil
+		}
+		return "", nil
+	}
+
+	if !sameModTime(id.ModTime, current.ModTime) {
+		return fmt.Sprintf("it was modified at %s when marked, now %s",
+			id.ModTime.Format(time.RFC3339), current.ModTime.Format(time.RFC3339)), nil
+	}
+
+	return "", nil
+}
+
+// modTimeTolerance allows for replicas that keep modification times to the
+// second, or to two seconds as FAT, exFAT and some SMB shares do.
+const modTimeTolerance = 2 * time.Second
+
+// sameModTime reports whether two modification times are the same once
+// the precision lost by copying and by the file system is allowed for.
+func sameModTime(a, b time.Time) bool {
+	diff := a.Truncate(time.Second).Sub(b.Truncate(time.Second))
+	return diff <= modTimeTolerance && diff >= -modTimeTolerance
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
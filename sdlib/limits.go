@@ -0,0 +1,153 @@
+package sdlib
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+)
+
+// DeletionLimits are circuit breakers against a corrupted or malicious
+// batch of SD files deleting a large part of a directory in one sweep.
+// A limit of zero is not checked.
+type DeletionLimits struct {
+	// MaxTargets is the most targets a sweep of one root may delete.
+	MaxTargets int
+	// MaxBytes is the most bytes of targets a sweep of one root may delete.
+	MaxBytes int64
+	// MaxPercent is the largest share of the files and folders in any one
+	// folder under a root, the root included, as a percentage, that a sweep
+	// of it may delete. A folder deleted whole counts once, in the folder
+	// holding it, so emptying a folder is caught however large the root.
+	MaxPercent float64
+}
+
+func (l DeletionLimits) enabled() bool {
+	return l.MaxTargets > 0 || l.MaxBytes > 0 || l.MaxPercent > 0
+}
+
+// LimitExceededError is returned when a sweep is abandoned before deleting
+// anything because its plan breaks one of the DeletionLimits.
+type LimitExceededError struct {
+	Root string
+	// Limit is the limit broken, "max-targets", "max-bytes" or "max-percent".
+	Limit string
+	// Planned is what the sweep would have deleted and Max is the limit,
+	// both in the limit's units.
+	Planned, Max float64
+	// Dir is the folder that would have lost the largest share of its
+	// files and folders, for "max-percent".
+	Dir string
+}
+
+func (e *LimitExceededError) Error() string {
+	var what string
+	switch e.Limit {
+	case "max-targets":
+		what = fmt.Sprintf("%.0f targets, more than the limit of %.0f", e.Planned, e.Max)
+	case "max-bytes":
+		what = fmt.Sprintf("%.0f bytes, more than the limit of %.0f", e.Planned, e.Max)
+	default:
+		what = fmt.Sprintf("%.1f%% of the files and folders in '%s', more than the limit of %.1f%%",
+			e.Planned, e.Dir, e.Max)
+	}
+	return fmt.Sprintf("not sweeping '%s' - it would delete %s", e.Root, what)
+}
+
+// deletionStats measures how much of a root a plan would delete.
+type deletionStats struct {
+	Targets int
+	Bytes   int64
+	// WorstDir is the folder that would lose the largest share of the files
+	// and folders directly in it, and WorstPercent is that share.
+	WorstDir     string
+	WorstPercent float64
+}
+
+// dirCount is the number of files and folders directly in a folder and
+// how many of them are targets.
+type dirCount struct {
+	deleted, total int
+}
+
+// measureDeletions walks root to measure the targets plan would delete.
+// SD folders and excluded paths are not counted.
+func measureDeletions(plan *SweepPlan, root string, exclude []string) deletionStats {
+	targets := make(map[string]bool)
+	for _, entry := range plan.Entries {
+		if entry.Kind == TargetDeletion {
+			targets[entry.Path] = true
+		}
+	}
+
+	stats := deletionStats{Targets: len(targets)}
+	counts := make(map[string]*dirCount)
+	dirs := make([]string, 0)
+	// WalkDir visits a folder's contents straight after the folder, so
+	// everything within the last target found follows it.
+	var deleting string
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == root {
+			return nil
+		}
+		if d.IsDir() && (d.Name() == SdFolderName || isExcluded(path, exclude)) {
+			return filepath.SkipDir
+		}
+
+		if len(deleting) == 0 || !isWithin(path, deleting) {
+			deleting = ""
+			dir := filepath.Dir(path)
+			count, found := counts[dir]
+			if !found {
+				count = &dirCount{}
+				counts[dir] = count
+				dirs = append(dirs, dir)
+			}
+			count.total++
+			if targets[path] {
+				count.deleted++
+				deleting = path
+			}
+		}
+		if len(deleting) == 0 {
+			return nil
+		}
+
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				stats.Bytes += info.Size()
+			}
+		}
+		return nil
+	})
+
+	for _, dir := range dirs {
+		count := counts[dir]
+		if percent := 100 * float64(count.deleted) / float64(count.total); percent > stats.WorstPercent {
+			stats.WorstDir = dir
+			stats.WorstPercent = percent
+		}
+	}
+	return stats
+}
+
+// checkLimits returns a *LimitExceededError if plan would delete more of
+// root than limits allow.
+func checkLimits(plan *SweepPlan, root string, limits DeletionLimits, exclude []string) error {
+	if !limits.enabled() {
+		return nil
+	}
+	stats := measureDeletions(plan, root, exclude)
+
+	switch {
+	case limits.MaxTargets > 0 && stats.Targets > limits.MaxTargets:
+		return &LimitExceededError{Root: root, Limit: "max-targets",
+			Planned: float64(stats.Targets), Max: float64(limits.MaxTargets)}
+	case limits.MaxBytes > 0 && stats.Bytes > limits.MaxBytes:
+		return &LimitExceededError{Root: root, Limit: "max-bytes",
+			Planned: float64(stats.Bytes), Max: float64(limits.MaxBytes)}
+	case limits.MaxPercent > 0 && stats.WorstPercent > limits.MaxPercent:
+		return &LimitExceededError{Root: root, Limit: "max-percent",
+			Planned: stats.WorstPercent, Max: limits.MaxPercent, Dir: stats.WorstDir}
+	}
+	return nil
+}
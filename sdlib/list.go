@@ -0,0 +1,119 @@
+package sdlib
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// MarkInfo describes a mark found by ListMarks.
+type MarkInfo struct {
+	SdFile string `json:"sdFile"`
+	// Target is the marked file, or the pattern joined to its folder for
+	// pattern marks.
+	Target    string    `json:"target"`
+	Pattern   string    `json:"pattern,omitempty"`
+	Recursive bool      `json:"recursive,omitempty"`
+	Action    Action    `json:"action"`
+	MarkedAt  time.Time `json:"markedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	// NotBefore is when a mark for deletion becomes due, or zero if it
+	// wasn't given one.
+	NotBefore    time.Time `json:"notBefore"`
+	TargetExists bool      `json:"targetExists"`
+}
+
+// Age is how long ago the mark was made.
+func (m MarkInfo) Age() time.Duration {
+	return time.Since(m.MarkedAt)
+}
+
+// ListMarks finds every well-formed mark under root. Malformed SD files are
+// logged as warnings and skipped.
+func ListMarks(root string, expiryMonths int, logger *slog.Logger) ([]MarkInfo, error) {
+	marks := make([]MarkInfo, 0)
+	err := readMarks(root, loggerOrDefault(logger), func(mark ActionForFile) error {
+		info := MarkInfo{
+			SdFile:    mark.SdFile,
+			Target:    mark.File,
+			Pattern:   mark.Pattern,
+			Recursive: mark.Recursive,
+			Action:    mark.Action,
+			MarkedAt:  mark.MarkTime(),
+			ExpiresAt: mark.ExpiryTime(expiryMonths),
+			NotBefore: mark.NotBefore,
+		}
+
+		if len(mark.Pattern) > 0 {
+			matches, err := expandPattern(filepath.Dir(filepath.Dir(mark.SdFile)), mark.Pattern, mark.Recursive, nil)
+			info.TargetExists = err == nil && len(matches) > 0
+		} else {
+			_, err := os.Lstat(mark.File)
+			info.TargetExists = err == nil
+		}
+
+		marks = append(marks, info)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return marks, nil
+}
+
+// readMarks calls fn with each well-formed mark in the SD folders under
+// root. Malformed SD files are logged as warnings and skipped.
+func readMarks(root string, logger *slog.Logger, fn func(mark ActionForFile) error) error {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+
+	sdFolders, err := findSdFolders(absRoot, defaultScanWorkers, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, sdFolder := range sdFolders {
+		dirEntries, err := os.ReadDir(sdFolder)
+		if err != nil {
+			return err
+		}
+
+		for _, dirEntry := range dirEntries {
+			if dirEntry.IsDir() || filepath.Ext(dirEntry.Name()) != ".txt" {
+				continue
+			}
+
+			sdFile := filepath.Join(sdFolder, dirEntry.Name())
+			if !sdFileNameRe.MatchString(dirEntry.Name()) {
+				logger.Warn("Skipping file with an illegal name for an SD file", SdFileKey, sdFile)
+				continue
+			}
+
+			mark, err := GetActionForFile(sdFile, filepath.Dir(sdFolder))
+			if errors.Is(err, ErrHostileSdFile) {
+				logger.Warn("Skipping hostile SD file", SdFileKey, sdFile, ErrorKey, err)
+				continue
+			}
+			var malformed *MalformedSdFileError
+			if errors.As(err, &malformed) {
+				logger.Warn("Skipping malformed SD file", SdFileKey, sdFile, ErrorKey, err)
+				continue
+			}
+			if err != nil {
+				logger.Error("Unable to read SD file", SdFileKey, sdFile, ErrorKey, err)
+				continue
+			}
+
+			if err := fn(mark); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
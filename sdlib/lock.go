@@ -0,0 +1,325 @@
+package sdlib
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LockFileName is the advisory lock file SweepDirectory holds at the root
+// of each sweep.
+const LockFileName = ".staydeleted.lock"
+
+// DefaultStaleLockAge is how old a lock from another host has to be before
+// it is taken to be stale, as its process can't be checked. Such locks are
+// usually copied from another machine by a sync tool.
+const DefaultStaleLockAge = 24 * time.Hour
+
+// unreadableLockAge is how old a lock file that can't be read has to be
+// before it is taken to be stale. A sweep that dies between making its lock
+// and writing to it leaves it empty.
+const unreadableLockAge = time.Minute
+
+// lockPollInterval is how often a sweep waiting for a lock checks it again.
+const lockPollInterval = time.Second
+
+// LockHeldError is returned when a sweep is skipped because its root is
+// locked by another sweep or by one of the external lock files.
+type LockHeldError struct {
+	Root string
+	// LockFile is the lock file that is held.
+	LockFile string
+	// Holder describes who holds the lock, if known.
+	Holder string
+}
+
+func (e *LockHeldError) Error() string {
+	if len(e.Holder) > 0 {
+		return fmt.Sprintf("skipping '%s' - '%s' is held by %s", e.Root, e.LockFile, e.Holder)
+	}
+	return fmt.Sprintf("skipping '%s' - '%s' is present", e.Root, e.LockFile)
+}
+
+type lockInfo struct {
+	PID     int
+	Host    string
+	Started time.Time
+}
+
+func (l lockInfo) String() string {
+	return fmt.Sprintf("process %d on %s since %s", l.PID, l.Host, l.Started.Format("2006-01-02 15:04:05"))
+}
+
+// acquireSweepLock waits up to opts.LockWait for the external lock files to
+// go away and for the locks at root and the folders containing it to be
+// free, then takes the lock. The returned function releases it. It stops
+// waiting when ctx is done. Sweeps of folders within root are left to
+// nestedLocks.
+func acquireSweepLock(ctx context.Context, root string, opts SweepOptions) (func(), error) {
+	absRoot, err := checkRoot(root)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(opts.LockWait)
+	waiting := false
+	for {
+		err := tryLock(absRoot, opts)
+		if err == nil {
+			lockFile := filepath.Join(absRoot, LockFileName)
+			return func() { os.Remove(lockFile) }, nil
+		}
+
+		var held *LockHeldError
+		if !errors.As(err, &held) || !time.Now().Before(deadline) {
+			return nil, err
+		}
+
+		if !waiting {
+			loggerOrDefault(opts.Logger).Info("Waiting for lock", "lock_file", held.LockFile, "holder", held.Holder)
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+func tryLock(absRoot string, opts SweepOptions) error {
+	for _, externalLock := range opts.ExternalLocks {
+		if _, err := os.Stat(externalLock); err == nil {
+			return &LockHeldError{Root: absRoot, LockFile: externalLock}
+		}
+	}
+
+	lockFileName := filepath.Join(absRoot, LockFileName)
+	hostname, _ := os.Hostname()
+
+	// A second attempt is made if a stale lock was taken over.
+	for attempt := 0; attempt < 2; attempt++ {
+		lockFile, err := os.OpenFile(lockFileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			err = writeLockInfo(lockFile, hostname)
+			if closeErr := lockFile.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(lockFileName)
+				return err
+			}
+
+			// The folders containing root are checked once the lock is
+			// taken, so that a sweep of one of them that starts at the same
+			// time sees this lock if this sweep doesn't see its lock.
+			if held := heldAncestorLock(absRoot, hostname, opts); held != nil {
+				os.Remove(lockFileName)
+				return held
+			}
+			return nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+
+		holder, held, err := lockHolder(lockFileName, hostname, opts)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return &LockHeldError{Root: absRoot, LockFile: lockFileName}
+		}
+		if held {
+			return newLockHeldError(absRoot, lockFileName, holder)
+		}
+
+		if err := removeStaleLock(absRoot, lockFileName, holder, hostname, opts); err != nil {
+			return err
+		}
+	}
+
+	return &LockHeldError{Root: absRoot, LockFile: lockFileName}
+}
+
+func newLockHeldError(root, lockFileName string, holder *lockInfo) *LockHeldError {
+	held := &LockHeldError{Root: root, LockFile: lockFileName}
+	if holder != nil {
+		held.Holder = holder.String()
+	}
+	return held
+}
+
+// lockHolder reads a lock file and reports whether it is held and, if it
+// could be read, by whom. A lock file that can't be read is held until it
+// is older than unreadableLockAge.
+func lockHolder(lockFileName, hostname string, opts SweepOptions) (*lockInfo, bool, error) {
+	holder, err := readLock(lockFileName)
+	if err == nil {
+		return &holder, !isStale(holder, hostname, opts), nil
+	}
+
+	info, err := os.Stat(lockFileName)
+	if err != nil {
+		return nil, false, err
+	}
+	return nil, time.Since(info.ModTime()) < unreadableLockAge, nil
+}
+
+// heldLock returns a *LockHeldError if a sweep holds the lock file, which
+// stops a sweep of root.
+func heldLock(root, lockFileName, hostname string, opts SweepOptions) *LockHeldError {
+	holder, held, err := lockHolder(lockFileName, hostname, opts)
+	if err != nil || !held {
+		return nil
+	}
+	return newLockHeldError(root, lockFileName, holder)
+}
+
+// heldAncestorLock returns a *LockHeldError if a sweep holds the lock of a
+// folder containing absRoot, up to the top of the file system.
+func heldAncestorLock(absRoot, hostname string, opts SweepOptions) *LockHeldError {
+	for dir := filepath.Dir(absRoot); ; {
+		if held := heldLock(absRoot, filepath.Join(dir, LockFileName), hostname, opts); held != nil {
+			return held
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil
+		}
+		dir = parent
+	}
+}
+
+// nestedLocks finds the SD folders under a root that are in a folder
+// another sweep holds the lock of, so that a sweep of the root leaves them
+// to it. Each folder's lock is only checked once.
+type nestedLocks struct {
+	root     string
+	hostname string
+	opts     SweepOptions
+	// held are the folders checked, with the lock held on each, if any.
+	held map[string]*LockHeldError
+}
+
+func newNestedLocks(root string, opts SweepOptions) *nestedLocks {
+	hostname, _ := os.Hostname()
+	return &nestedLocks{root: root, hostname: hostname, opts: opts, held: make(map[string]*LockHeldError)}
+}
+
+// heldFor returns the lock held on a folder between the root and sdFolder,
+// or nil. It logs the first SD folder skipped for each lock.
+func (n *nestedLocks) heldFor(sdFolder string) *LockHeldError {
+	for dir := filepath.Dir(sdFolder); isWithin(dir, n.root); dir = filepath.Dir(dir) {
+		held, checked := n.held[dir]
+		if !checked {
+			held = heldLock(n.root, filepath.Join(dir, LockFileName), n.hostname, n.opts)
+			n.held[dir] = held
+			if held != nil {
+				loggerOrDefault(n.opts.Logger).Warn("Leaving folder to the sweep that holds its lock", "dir", dir,
+					"lock_file", held.LockFile, "holder", held.Holder)
+			}
+		}
+		if held != nil {
+			return held
+		}
+	}
+	return nil
+}
+
+// removeStaleLock removes the lock file if it is still held by stale.
+// Another sweep may have found the same stale lock, removed it and taken
+// the lock in the meantime, so the lock is first moved aside, which only
+// one sweep can do, and put back if it is no longer the stale one. Then
+// the lock is held, and a *LockHeldError is returned. A stale lock that
+// couldn't be read, with no holder, must still be unreadable and stale.
+func removeStaleLock(absRoot, lockFileName string, stale *lockInfo, hostname string, opts SweepOptions) error {
+	aside := fmt.Sprintf("%s.%d.stale", lockFileName, os.Getpid())
+	if err := os.Rename(lockFileName, aside); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	holder, held, err := lockHolder(aside, hostname, opts)
+	if err != nil {
+		return err
+	}
+	if !held && ((stale == nil && holder == nil) || (stale != nil && holder != nil &&
+		holder.PID == stale.PID && holder.Host == stale.Host && holder.Started.Equal(stale.Started))) {
+		return os.Remove(aside)
+	}
+
+	// Linking it back fails rather than replace a lock taken since, in
+	// which case the lock moved aside is no longer needed to hold it.
+	heldErr := newLockHeldError(absRoot, lockFileName, holder)
+	err = os.Link(aside, lockFileName)
+	switch {
+	case err == nil || os.IsExist(err):
+		os.Remove(aside)
+	default:
+		// Hard links aren't supported by every file system.
+		if err := os.Rename(aside, lockFileName); err != nil {
+			return err
+		}
+	}
+	return heldErr
+}
+
+// writeLockInfo writes the lockInfo of this process to w.
+func writeLockInfo(w io.Writer, hostname string) error {
+	_, err := fmt.Fprintf(w, "pid: %d\nhost: %s\nstarted: %s\n",
+		os.Getpid(), hostname, time.Now().UTC().Format(time.RFC3339))
+	return err
+}
+
+func isStale(holder lockInfo, hostname string, opts SweepOptions) bool {
+	if holder.Host == hostname {
+		return !processAlive(holder.PID)
+	}
+
+	staleAge := opts.StaleLockAge
+	if staleAge <= 0 {
+		staleAge = DefaultStaleLockAge
+	}
+	return time.Since(holder.Started) > staleAge
+}
+
+func readLock(lockFileName string) (lockInfo, error) {
+	lockFile, err := os.Open(lockFileName)
+	if err != nil {
+		return lockInfo{}, err
+	}
+	defer lockFile.Close()
+
+	var holder lockInfo
+	input := bufio.NewScanner(lockFile)
+	for input.Scan() {
+		key, value, _ := strings.Cut(input.Text(), ": ")
+		switch key {
+		case "pid":
+			holder.PID, err = strconv.Atoi(value)
+		case "host":
+			holder.Host = value
+		case "started":
+			holder.Started, err = time.Parse(time.RFC3339, value)
+		}
+		if err != nil {
+			return lockInfo{}, err
+		}
+	}
+
+	if holder.PID == 0 {
+		return lockInfo{}, fmt.Errorf("'%s' has no process ID", lockFileName)
+	}
+	return holder, input.Err()
+}
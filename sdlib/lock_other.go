@@ -0,0 +1,15 @@
+//go:build !unix
+
+package sdlib
+
+import "os"
+
+// processAlive reports whether a process with the given ID is running.
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release()
+	return true
+}
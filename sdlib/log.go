@@ -0,0 +1,260 @@
+package sdlib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// The attribute keys used in log records, so that every record about a
+// root or a file can be found whichever part of the library logged it.
+const (
+	RootKey   = "root"
+	SdFileKey = "sd_file"
+	TargetKey = "target"
+	ActionKey = "action"
+	ErrorKey  = "error"
+)
+
+// NewLogger creates a logger in the format "text" or "json" that writes
+// records below level Warn to outWriter and the rest to errWriter.
+func NewLogger(format string, level slog.Leveler, outWriter io.Writer, errWriter io.Writer) (*slog.Logger, error) {
+	handlerOpts := &slog.HandlerOptions{Level: level}
+
+	var newHandler func(w io.Writer) slog.Handler
+	switch format {
+	case "", "text":
+		newHandler = func(w io.Writer) slog.Handler { return slog.NewTextHandler(w, handlerOpts) }
+	case "json":
+		newHandler = func(w io.Writer) slog.Handler { return slog.NewJSONHandler(w, handlerOpts) }
+	default:
+		return nil, fmt.Errorf("unknown log format '%s', expecting text or json", format)
+	}
+
+	return slog.New(&splitHandler{out: newHandler(outWriter), err: newHandler(errWriter)}), nil
+}
+
+// splitHandler sends warnings and errors to one handler and everything
+// else to another.
+type splitHandler struct {
+	out, err slog.Handler
+}
+
+func (h *splitHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if level >= slog.LevelWarn {
+		return h.err.Enabled(ctx, level)
+	}
+	return h.out.Enabled(ctx, level)
+}
+
+func (h *splitHandler) Handle(ctx context.Context, record slog.Record) error {
+	if record.Level >= slog.LevelWarn {
+		return h.err.Handle(ctx, record)
+	}
+	return h.out.Handle(ctx, record)
+}
+
+func (h *splitHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &splitHandler{out: h.out.WithAttrs(attrs), err: h.err.WithAttrs(attrs)}
+}
+
+func (h *splitHandler) WithGroup(name string) slog.Handler {
+	return &splitHandler{out: h.out.WithGroup(name), err: h.err.WithGroup(name)}
+}
+
+// bufferedHandler holds records back in a temporary file until they are
+// flushed, so that the records of one task can be written together without
+// keeping them in memory. Handlers derived from it share its spool and
+// records keep the handler they were logged with.
+type bufferedHandler struct {
+	handler slog.Handler
+	// index is the position of handler in the spool's handlers.
+	index int
+	spool *recordSpool
+}
+
+// recordSpool holds records one JSON object per line. The file is made
+// when the first record is logged.
+type recordSpool struct {
+	mu       sync.Mutex
+	file     *os.File
+	handlers []slog.Handler
+}
+
+type spooledRecord struct {
+	Handler int           `json:"handler"`
+	Time    time.Time     `json:"time"`
+	Level   slog.Level    `json:"level"`
+	Message string        `json:"message"`
+	PC      uintptr       `json:"pc"`
+	Attrs   []spooledAttr `json:"attrs"`
+}
+
+// spooledAttr holds an attribute's value by its kind. Values of kind Any,
+// such as errors, are kept as their text.
+type spooledAttr struct {
+	Key    string        `json:"key"`
+	Kind   slog.Kind     `json:"kind"`
+	String string        `json:"string,omitempty"`
+	Int    int64         `json:"int,omitempty"`
+	Uint   uint64        `json:"uint,omitempty"`
+	Float  float64       `json:"float,omitempty"`
+	Bool   bool          `json:"bool,omitempty"`
+	Time   *time.Time    `json:"time,omitempty"`
+	Group  []spooledAttr `json:"group,omitempty"`
+}
+
+// newBufferedLogger returns a logger that holds its records back and a
+// function that writes them to logger. If they can't be held back, records
+// are written to logger straight away.
+func newBufferedLogger(logger *slog.Logger) (*slog.Logger, func()) {
+	spool := &recordSpool{handlers: []slog.Handler{logger.Handler()}}
+	return slog.New(&bufferedHandler{handler: logger.Handler(), spool: spool}), spool.flush
+}
+
+func (h *bufferedHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h *bufferedHandler) Handle(ctx context.Context, record slog.Record) error {
+	spooled := spooledRecord{Handler: h.index, Time: record.Time, Level: record.Level,
+		Message: record.Message, PC: record.PC}
+	record.Attrs(func(attr slog.Attr) bool {
+		spooled.Attrs = append(spooled.Attrs, spoolAttr(attr))
+		return true
+	})
+	line, err := json.Marshal(spooled)
+	if err != nil {
+		return h.handler.Handle(ctx, record)
+	}
+
+	h.spool.mu.Lock()
+	defer h.spool.mu.Unlock()
+	if h.spool.file == nil {
+		file, err := os.CreateTemp("", "staydeleted-log-*.jsonl")
+		if err != nil {
+			return h.handler.Handle(ctx, record)
+		}
+		h.spool.file = file
+	}
+	if _, err := h.spool.file.Write(append(line, '\n')); err != nil {
+		return h.handler.Handle(ctx, record)
+	}
+	return nil
+}
+
+func (h *bufferedHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.spool.derive(h.handler.WithAttrs(attrs))
+}
+
+func (h *bufferedHandler) WithGroup(name string) slog.Handler {
+	return h.spool.derive(h.handler.WithGroup(name))
+}
+
+func (s *recordSpool) derive(handler slog.Handler) *bufferedHandler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers = append(s.handlers, handler)
+	return &bufferedHandler{handler: handler, index: len(s.handlers) - 1, spool: s}
+}
+
+// flush writes the spooled records to their handlers and removes the file.
+func (s *recordSpool) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return
+	}
+	defer func() {
+		s.file.Close()
+		os.Remove(s.file.Name())
+		s.file = nil
+	}()
+
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	dec := json.NewDecoder(s.file)
+	for {
+		var spooled spooledRecord
+		if err := dec.Decode(&spooled); err != nil {
+			return
+		}
+		record := slog.NewRecord(spooled.Time, spooled.Level, spooled.Message, spooled.PC)
+		for _, attr := range spooled.Attrs {
+			record.AddAttrs(attr.attr())
+		}
+		s.handlers[spooled.Handler].Handle(context.Background(), record)
+	}
+}
+
+func spoolAttr(attr slog.Attr) spooledAttr {
+	value := attr.Value.Resolve()
+	spooled := spooledAttr{Key: attr.Key, Kind: value.Kind()}
+	switch value.Kind() {
+	case slog.KindString:
+		spooled.String = value.String()
+	case slog.KindInt64:
+		spooled.Int = value.Int64()
+	case slog.KindDuration:
+		spooled.Int = int64(value.Duration())
+	case slog.KindUint64:
+		spooled.Uint = value.Uint64()
+	case slog.KindFloat64:
+		spooled.Float = value.Float64()
+	case slog.KindBool:
+		spooled.Bool = value.Bool()
+	case slog.KindTime:
+		t := value.Time()
+		spooled.Time = &t
+	case slog.KindGroup:
+		for _, member := range value.Group() {
+			spooled.Group = append(spooled.Group, spoolAttr(member))
+		}
+	default:
+		spooled.Kind = slog.KindString
+		spooled.String = fmt.Sprint(value.Any())
+	}
+	return spooled
+}
+
+func (a spooledAttr) attr() slog.Attr {
+	switch a.Kind {
+	case slog.KindInt64:
+		return slog.Int64(a.Key, a.Int)
+	case slog.KindDuration:
+		return slog.Duration(a.Key, time.Duration(a.Int))
+	case slog.KindUint64:
+		return slog.Uint64(a.Key, a.Uint)
+	case slog.KindFloat64:
+		return slog.Float64(a.Key, a.Float)
+	case slog.KindBool:
+		return slog.Bool(a.Key, a.Bool)
+	case slog.KindTime:
+		var t time.Time
+		if a.Time != nil {
+			t = *a.Time
+		}
+		return slog.Time(a.Key, t)
+	case slog.KindGroup:
+		members := make([]any, 0, len(a.Group))
+		for _, member := range a.Group {
+			members = append(members, member.attr())
+		}
+		return slog.Group(a.Key, members...)
+	}
+	return slog.String(a.Key, a.String)
+}
+
+// loggerOrDefault is l, or slog's default logger if the caller didn't give one.
+func loggerOrDefault(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return slog.Default()
+	}
+	return l
+}
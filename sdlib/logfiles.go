@@ -0,0 +1,369 @@
+package sdlib
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LatestLogName is a symlink in the logs folder to the newest .log file.
+const LatestLogName = "latest.log"
+
+const logTimeFormat = "2006-01-02_15.04.05"
+
+var logFileNameRe = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}_\d{2}\.\d{2}\.\d{2}(-\d+)?\.(log|err)(\.gz)?$`)
+
+// LogRetention controls the log files that GetWriters keeps.
+type LogRetention struct {
+	// MaxAge is how long log files are kept. Zero keeps them forever.
+	MaxAge time.Duration
+	// MaxCount is how many .log files, and how many .err files, are kept.
+	// Zero keeps any number.
+	MaxCount int
+	// MaxSize is the size in bytes at which a log file is closed and a new
+	// one started. Zero keeps writing to the same file.
+	MaxSize int64
+	// Compress gzips log files once they are no longer written to.
+	Compress bool
+}
+
+// GetWriters returns the writers for the output and the errors of a run,
+// and a function that closes them. With a logs directory, they write to
+// timestamped .log and .err files in its staydeleted folder, which is
+// tidied up to retention. The .err file is only made if there are errors.
+// Without one, they are stdout and stderr.
+func GetWriters(logsDir string, retention LogRetention) (io.Writer, io.Writer, func() error, error) {
+	if len(logsDir) == 0 {
+		return os.Stdout, os.Stderr, func() error { return nil }, nil
+	}
+
+	rootLogFolder, err := filepath.Abs(logsDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	sdLogFolder := filepath.Join(rootLogFolder, "staydeleted")
+	if err := os.MkdirAll(sdLogFolder, 0755); err != nil {
+		return nil, nil, nil, err
+	}
+
+	folder := &logFolder{dir: sdLogFolder, retention: retention, started: time.Now(),
+		current: make(map[string]bool), made: make(map[string]bool)}
+	outLog := &logFile{folder: folder, ext: ".log", latest: true}
+	errLog := &logFile{folder: folder, ext: ".err"}
+
+	// The .log file is made straight away so that latest.log is this run's.
+	outLog.mu.Lock()
+	err = outLog.openFile()
+	outLog.mu.Unlock()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	folder.prune()
+
+	closeLogs := func() error {
+		return errors.Join(outLog.Close(), errLog.Close())
+	}
+	return outLog, errLog, closeLogs, nil
+}
+
+// logFolder is a folder of log files kept to a retention. Several runs,
+// such as a daemon and a sweep from cron, may share the folder, so each
+// run holds the files it is writing to with a hold file beside them.
+type logFolder struct {
+	dir       string
+	retention LogRetention
+	// started is when this run started.
+	started time.Time
+
+	mu sync.Mutex
+	// current are the names of the files being written to and made are
+	// the names of all the files this run has made.
+	current, made map[string]bool
+}
+
+// holdFileName is the name of the file that holds the log file name
+// while a run writes to it.
+func holdFileName(name string) string {
+	return "." + name + ".held"
+}
+
+// hold records that this run is writing to the log file name. The hold
+// file is made before the log file, so that other runs never see the log
+// file unheld. If another run holds the name, the error satisfies
+// os.IsExist.
+func (f *logFolder) hold(name string) error {
+	holdFile, err := os.OpenFile(filepath.Join(f.dir, holdFileName(name)), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	hostname, _ := os.Hostname()
+	err = writeLockInfo(holdFile, hostname)
+	return errors.Join(err, holdFile.Close())
+}
+
+func (f *logFolder) release(name string) {
+	os.Remove(filepath.Join(f.dir, holdFileName(name)))
+}
+
+// heldByOther reports whether another run is writing to the log file name.
+// Hold files left by runs that have died are removed.
+func (f *logFolder) heldByOther(name string) bool {
+	holdFile := filepath.Join(f.dir, holdFileName(name))
+	holder, err := readLock(holdFile)
+	if os.IsNotExist(err) {
+		return false
+	}
+	if err != nil {
+		// It may be being written, so it is taken to be held.
+		return true
+	}
+
+	hostname, _ := os.Hostname()
+	if isStale(holder, hostname, SweepOptions{}) {
+		os.Remove(holdFile)
+		return false
+	}
+	return true
+}
+
+func (f *logFolder) setCurrent(name string, current bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if current {
+		f.current[name] = true
+		f.made[name] = true
+	} else {
+		delete(f.current, name)
+	}
+}
+
+// prune removes empty log files and the log files outside the retention,
+// and compresses the rest if asked to. Files being written to, by this run
+// or another, are left alone, as are files another run made after this
+// one started.
+func (f *logFolder) prune() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return
+	}
+
+	currentKinds := make(map[string]int)
+	for name := range f.current {
+		currentKinds[filepath.Ext(name)]++
+	}
+
+	kept := make(map[string][]string)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !logFileNameRe.MatchString(name) || f.current[name] {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if !f.made[name] && !info.ModTime().Before(f.started) {
+			continue
+		}
+		if f.heldByOther(strings.TrimSuffix(name, ".gz")) {
+			continue
+		}
+
+		path := filepath.Join(f.dir, name)
+		kind := filepath.Ext(strings.TrimSuffix(name, ".gz"))
+		switch {
+		// Runs with nothing to say leave nothing behind.
+		case info.Size() == 0:
+			os.Remove(path)
+		case f.retention.MaxAge > 0 && time.Since(info.ModTime()) > f.retention.MaxAge:
+			os.Remove(path)
+		default:
+			kept[kind] = append(kept[kind], name)
+		}
+	}
+
+	for kind, names := range kept {
+		// Newest first, as the names start with when they were made.
+		sort.Sort(sort.Reverse(sort.StringSlice(names)))
+
+		if f.retention.MaxCount > 0 {
+			keep := f.retention.MaxCount - currentKinds[kind]
+			if keep < 0 {
+				keep = 0
+			}
+			if len(names) > keep {
+				for _, name := range names[keep:] {
+					os.Remove(filepath.Join(f.dir, name))
+				}
+				names = names[:keep]
+			}
+		}
+
+		if f.retention.Compress {
+			for _, name := range names {
+				if !strings.HasSuffix(name, ".gz") {
+					gzipFile(filepath.Join(f.dir, name))
+				}
+			}
+		}
+	}
+}
+
+// gzipFile replaces path with a gzipped copy with the same modification time.
+func gzipFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// Another run pruning the folder at the same time may be compressing
+	// the same file.
+	gzPath := path + ".gz"
+	dst, err := os.OpenFile(gzPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	err = errors.Join(err, zw.Close(), dst.Close())
+	if err != nil {
+		os.Remove(gzPath)
+		return err
+	}
+
+	os.Chtimes(gzPath, info.ModTime(), info.ModTime())
+	src.Close()
+	return os.Remove(path)
+}
+
+// logFile writes to a timestamped file in a logFolder. The file is made on
+// the first write and replaced with a new one when it reaches MaxSize.
+type logFile struct {
+	folder *logFolder
+	ext    string
+	// latest keeps latest.log pointing at the file.
+	latest bool
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	maxSize := l.folder.retention.MaxSize
+	if l.file != nil && maxSize > 0 && l.size > 0 && l.size+int64(len(p)) > maxSize {
+		if err := l.closeFile(); err != nil {
+			return 0, err
+		}
+		if err := l.openFile(); err != nil {
+			return 0, err
+		}
+		l.folder.prune()
+	}
+
+	if l.file == nil {
+		if err := l.openFile(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := l.file.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// Close closes the file, if it was made.
+func (l *logFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	return l.closeFile()
+}
+
+// openFile makes a new file named for the time, adding a number if a file
+// was already made in the same second.
+func (l *logFile) openFile() error {
+	stamp := time.Now().Format(logTimeFormat)
+	for n := 0; ; n++ {
+		name := stamp + l.ext
+		if n > 0 {
+			name = fmt.Sprintf("%s-%d%s", stamp, n, l.ext)
+		}
+
+		err := l.folder.hold(name)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		file, err := os.OpenFile(filepath.Join(l.folder.dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			l.folder.release(name)
+		}
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		l.file = file
+		l.size = 0
+		l.folder.setCurrent(name, true)
+		if l.latest {
+			l.linkLatest(name)
+		}
+		return nil
+	}
+}
+
+func (l *logFile) closeFile() error {
+	err := l.file.Close()
+	name := filepath.Base(l.file.Name())
+	l.folder.setCurrent(name, false)
+	l.folder.release(name)
+	l.file = nil
+	return err
+}
+
+// linkLatest points latest.log at name. Where symlinks can't be made, as on
+// some Windows systems, there is no latest.log.
+func (l *logFile) linkLatest(name string) {
+	tmp := filepath.Join(l.folder.dir, "."+LatestLogName+".tmp")
+	os.Remove(tmp)
+	if err := os.Symlink(name, tmp); err != nil {
+		return
+	}
+	if err := os.Rename(tmp, filepath.Join(l.folder.dir, LatestLogName)); err != nil {
+		os.Remove(tmp)
+	}
+}
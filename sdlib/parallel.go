@@ -0,0 +1,110 @@
+package sdlib
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// dedupeRoots removes duplicate roots and roots inside other roots, as
+// sweeping the outer root already covers them. Roots are returned in their
+// original order.
+func dedupeRoots(roots []string, logger *slog.Logger) []string {
+	absRoots := make(map[string]string, len(roots))
+	sorted := make([]string, 0, len(roots))
+	for _, root := range roots {
+		absRoot, err := filepath.Abs(root)
+		if err != nil {
+			absRoot = filepath.Clean(root)
+		}
+		if _, found := absRoots[root]; !found {
+			absRoots[root] = absRoot
+			sorted = append(sorted, absRoot)
+		}
+	}
+	sort.Strings(sorted)
+
+	covering := make(map[string]string)
+	for _, absRoot := range sorted {
+		for _, other := range sorted {
+			if other != absRoot && isWithin(absRoot, other) {
+				covering[absRoot] = other
+				break
+			}
+		}
+	}
+
+	deduped := make([]string, 0, len(roots))
+	seen := make(map[string]bool)
+	for _, root := range roots {
+		absRoot := absRoots[root]
+		if seen[absRoot] {
+			continue
+		}
+		seen[absRoot] = true
+
+		if other, found := covering[absRoot]; found {
+			logger.Info("Skipping root inside another root", RootKey, root, "covering_root", other)
+			continue
+		}
+		deduped = append(deduped, root)
+	}
+
+	return deduped
+}
+
+// SweepDirectories sweeps each of the roots, opts.Jobs at a time. Roots
+// inside other roots are only swept once. When sweeping concurrently, the
+// records of each root are held in a temporary file and logged in one
+// piece when it finishes so that the roots don't interleave. A root that fails doesn't stop the others;
+// the errors of all the failed roots are returned together.
+func SweepDirectories(roots []string, opts SweepOptions) error {
+	logger := loggerOrDefault(opts.Logger)
+	roots = dedupeRoots(roots, logger)
+
+	jobs := opts.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	if jobs == 1 {
+		var errs []error
+		for _, root := range roots {
+			if err := SweepDirectory(root, opts); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", root, err))
+			}
+		}
+		return errors.Join(errs...)
+	}
+
+	var mu sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, jobs)
+	for _, root := range roots {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(root string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			rootOpts := opts
+			var flush func()
+			rootOpts.Logger, flush = newBufferedLogger(logger)
+			err := SweepDirectory(root, rootOpts)
+
+			mu.Lock()
+			defer mu.Unlock()
+			flush()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", root, err))
+			}
+		}(root)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,158 @@
+package sdlib
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// validatePattern checks that pattern is a well formed glob for base names.
+func validatePattern(pattern string) error {
+	if strings.ContainsAny(pattern, `/\`) {
+		return fmt.Errorf("pattern '%s' must not contain a path separator", pattern)
+	}
+
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return fmt.Errorf("pattern '%s' - %v", pattern, err)
+	}
+
+	return nil
+}
+
+// GetPatternSdFile returns the SD file for a pattern mark in dir. Pattern
+// SD files are named from the pattern so that re-marking the same pattern
+// replaces the earlier mark.
+func GetPatternSdFile(dir, pattern string) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	data := []byte("pattern:" + pattern)
+	return filepath.Join(absDir, SdFolderName, fmt.Sprintf("%x.txt", md5.Sum(data))), nil
+}
+
+// MarkPattern marks every file in dir whose base name matches pattern,
+// including files created later. If recursive is set, files in all the
+// subfolders of dir are matched too. The matches are found each time dir is
+// swept. Protected paths that match now are logged as warnings, as sweep
+// won't delete them.
+func MarkPattern(dir, pattern string, recursive bool, action Action, opts MarkOptions) error {
+	if err := validatePattern(pattern); err != nil {
+		return err
+	}
+	if err := opts.checkTimes(action); err != nil {
+		return err
+	}
+
+	stat, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !stat.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	sdFileName, err := GetPatternSdFile(dir, pattern)
+	if err != nil {
+		return err
+	}
+
+	logger := loggerOrDefault(opts.Logger)
+	if action == Delete && len(opts.Protected) > 0 {
+		warnProtectedMatches(dir, pattern, recursive, opts, logger)
+	}
+	logger.Info("Marking pattern", "pattern", pattern, "dir", filepath.Dir(filepath.Dir(sdFileName)),
+		ActionKey, action, SdFileKey, sdFileName)
+
+	return writeMark(sdFileName, SdRecord{
+		Pattern:   pattern,
+		Recursive: recursive,
+		Action:    action,
+		ExpiresAt: opts.ExpiresAt,
+		NotBefore: opts.NotBefore,
+	}, logger)
+}
+
+// warnProtectedMatches logs a warning for each protected path that a mark
+// to delete pattern in dir would match.
+func warnProtectedMatches(dir, pattern string, recursive bool, opts MarkOptions, logger *slog.Logger) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return
+	}
+	matches, err := expandPattern(absDir, pattern, recursive, nil)
+	if err != nil {
+		return
+	}
+
+	compiled := compileProtected(opts.Protected)
+	for _, match := range matches {
+		if reason := protection(match, opts.Protected, compiled); len(reason) > 0 {
+			logger.Warn("Pattern matches a protected path that sweep won't delete", TargetKey, match,
+				"pattern", pattern, "reason", reason)
+		}
+	}
+}
+
+// expandPattern finds the files under dir matched by a pattern mark,
+// leaving out anything matching the exclude patterns. A file
+// with its own SD file is governed by that mark instead, so that a single
+// file can be kept or deleted against the pattern. Matched folders are not
+// searched further.
+func expandPattern(dir, pattern string, recursive bool, exclude []string) ([]string, error) {
+	matches := make([]string, 0)
+	walker := func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+		if d.Name() == SdFolderName {
+			return filepath.SkipDir
+		}
+		if d.Name() == LockFileName {
+			return nil
+		}
+		if isExcluded(path, exclude) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		matched, _ := filepath.Match(pattern, d.Name())
+		if matched && !hasOwnMark(path) {
+			matches = append(matches, path)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() && !recursive {
+			return filepath.SkipDir
+		}
+		return nil
+	}
+
+	if err := filepath.WalkDir(dir, walker); err != nil {
+		return nil, err
+	}
+
+	return matches, nil
+}
+
+func hasOwnMark(path string) bool {
+	sdFile, err := GetSdFile(path)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(sdFile)
+	return err == nil
+}
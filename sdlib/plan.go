@@ -0,0 +1,589 @@
+package sdlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// EntryKind classifies what a sweep decided about an SD file or folder.
+type EntryKind int
+
+const (
+	// TargetDeletion is a file or folder that an SD file marks for deletion.
+	TargetDeletion EntryKind = iota
+	// ExpiredSdFile is an SD file older than the expiry cutoff.
+	ExpiredSdFile
+	// MalformedSdFile is an SD file with an illegal name or contents that
+	// can't be parsed.
+	MalformedSdFile
+	// EmptySdFolder is an SD folder with no SD files left in it.
+	EmptySdFolder
+	// AlreadyDeleted is a target marked for deletion that no longer exists.
+	AlreadyDeleted
+	// Kept is a target marked to be kept.
+	Kept
+	// SupersededSdFile is an SD file that lost to a newer mark for the same target.
+	SupersededSdFile
+	// ChangedTarget is a target that is not the file that was marked, for
+	// example because it was recreated after the original was deleted.
+	ChangedTarget
+	// Excluded is a target matching one of the sweep's exclude patterns.
+	Excluded
+	// HostileSdFile is an SD file naming a target outside its folder, or a
+	// target reached through a symlink. Neither is touched.
+	HostileSdFile
+	// Protected is a target that is, or holds, a protected path, or is the
+	// root being swept.
+	Protected
+	// Skipped is a target that was marked for deletion but not approved
+	// when the sweep was reviewed. It is left for the next sweep.
+	Skipped
+	// NotYetDue is a target marked for deletion after a time still to come.
+	NotYetDue
+	// UnreadableSdFile is an SD file that couldn't be opened or read. It is
+	// left in place and reported as a failure.
+	UnreadableSdFile
+)
+
+var entryKindNames = []string{
+	"target-deletion",
+	"expired-sd-file",
+	"malformed-sd-file",
+	"empty-sd-folder",
+	"already-deleted",
+	"kept",
+	"superseded-sd-file",
+	"changed-target",
+	"excluded",
+	"hostile-sd-file",
+	"protected",
+	"skipped",
+	"not-yet-due",
+	"unreadable-sd-file",
+}
+
+func (k EntryKind) String() string {
+	if k < 0 || int(k) >= len(entryKindNames) {
+		return fmt.Sprintf("EntryKind(%d)", int(k))
+	}
+	return entryKindNames[k]
+}
+
+func (k EntryKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+func (k *EntryKind) UnmarshalText(text []byte) error {
+	for i, name := range entryKindNames {
+		if name == string(text) {
+			*k = EntryKind(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown entry kind '%s'", text)
+}
+
+// Deletes reports whether executing an entry of this kind removes Path.
+func (k EntryKind) Deletes() bool {
+	switch k {
+	case TargetDeletion, ExpiredSdFile, MalformedSdFile, EmptySdFolder:
+		return true
+	}
+	return false
+}
+
+// PlanEntry is a single decision in a SweepPlan.
+type PlanEntry struct {
+	Kind EntryKind `json:"kind"`
+	// Path is the file or folder the entry is about. For SD file kinds this
+	// is the SD file itself, for target kinds it is the marked file.
+	Path string `json:"path"`
+	// SdFile is the SD file that ordered the decision, if any.
+	SdFile string `json:"sdFile,omitempty"`
+	// SdModTime is the modification time of SdFile, or of Path for SD file
+	// kinds. It is zero for empty SD folders.
+	SdModTime time.Time `json:"sdModTime"`
+	// MarkedAt is when the mark was made, as recorded in the SD file or
+	// taken from its modification time for legacy SD files. It is zero for
+	// entries without a mark, such as malformed SD files.
+	MarkedAt time.Time `json:"markedAt"`
+	// Pattern is set when the entry comes from a pattern mark.
+	Pattern string `json:"pattern,omitempty"`
+	// Reason explains why an SD file is malformed or a mark wasn't acted on.
+	Reason string `json:"reason,omitempty"`
+}
+
+// SweepPlan is everything a sweep of one or more roots would do.
+type SweepPlan struct {
+	Roots   []string    `json:"roots"`
+	Entries []PlanEntry `json:"entries"`
+}
+
+// Deletions returns the entries that remove something from the disk, in the
+// order they will be executed.
+func (p *SweepPlan) Deletions() []PlanEntry {
+	return p.Filter(func(e PlanEntry) bool { return e.Kind.Deletes() }).Entries
+}
+
+// Filter returns a new plan with only the entries for which keep returns true.
+func (p *SweepPlan) Filter(keep func(PlanEntry) bool) *SweepPlan {
+	filtered := &SweepPlan{Roots: p.Roots, Entries: make([]PlanEntry, 0)}
+	for _, entry := range p.Entries {
+		if keep(entry) {
+			filtered.Entries = append(filtered.Entries, entry)
+		}
+	}
+	return filtered
+}
+
+// MergePlans combines plans for several roots into one.
+func MergePlans(plans ...*SweepPlan) *SweepPlan {
+	merged := &SweepPlan{Roots: make([]string, 0), Entries: make([]PlanEntry, 0)}
+	for _, plan := range plans {
+		merged.Roots = append(merged.Roots, plan.Roots...)
+		merged.Entries = append(merged.Entries, plan.Entries...)
+	}
+	return merged
+}
+
+var sdFileNameRe = regexp.MustCompile(`[0-9a-fA-F]+.txt`)
+
+// PlanSweep walks root and classifies every SD folder and SD file found
+// without changing anything on the disk.
+func PlanSweep(root string, opts SweepOptions) (*SweepPlan, error) {
+	absRoot, err := checkRoot(root)
+	if err != nil {
+		return nil, err
+	}
+
+	planner := newPlanner(absRoot, opts)
+	workers := scanWorkers(opts)
+	sdFolders, err := findSdFolders(absRoot, workers, opts.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	// Folders another sweep holds the lock of are left to it.
+	locks := newNestedLocks(absRoot, opts)
+	unlocked := sdFolders[:0]
+	for _, sdFolder := range sdFolders {
+		if locks.heldFor(sdFolder) == nil {
+			unlocked = append(unlocked, sdFolder)
+		}
+	}
+	sdFolders = unlocked
+	// Deal with the contents of a marked folder before the folder itself,
+	// as a sweep streaming from the disk does.
+	sort.Slice(sdFolders, func(i, j int) bool { return childrenFirst(sdFolders[i], sdFolders[j]) })
+
+	// SD folders are classified concurrently, but the plan keeps them in
+	// the order they were found so that it is the same on every run.
+	folderEntries := make([][]PlanEntry, len(sdFolders))
+	folderErrs := make([]error, len(sdFolders))
+	forEachParallel(len(sdFolders), workers, func(i int) {
+		folderEntries[i], folderErrs[i] = planner.planSdFolder(sdFolders[i])
+	})
+
+	plan := &SweepPlan{Roots: []string{absRoot}, Entries: make([]PlanEntry, 0)}
+	for i, entries := range folderEntries {
+		if folderErrs[i] != nil {
+			return nil, folderErrs[i]
+		}
+		plan.Entries = append(plan.Entries, entries...)
+	}
+
+	return plan, nil
+}
+
+// childrenFirst orders SD folders so that those in a folder's subfolders
+// come before the folder's own, and otherwise by name.
+func childrenFirst(a, b string) bool {
+	aParts, bParts := splitPath(filepath.Dir(a)), splitPath(filepath.Dir(b))
+	for i := 0; i < len(aParts) && i < len(bParts); i++ {
+		if aParts[i] != bParts[i] {
+			return aParts[i] < bParts[i]
+		}
+	}
+	return len(aParts) > len(bParts)
+}
+
+// planner classifies the contents of SD folders for a sweep.
+type planner struct {
+	// root is the root being swept, if known.
+	root         string
+	now          time.Time
+	expiryMonths int
+	exclude      []string
+	protected    []string
+	compiled     [][]string
+}
+
+func newPlanner(root string, opts SweepOptions) *planner {
+	return &planner{
+		root:         root,
+		now:          time.Now(),
+		expiryMonths: opts.ExpiryMonths,
+		exclude:      opts.Exclude,
+		protected:    opts.Protected,
+		compiled:     compileProtected(opts.Protected),
+	}
+}
+
+// protection explains why target must not be deleted, or is "" if it may be.
+func (p *planner) protection(target string) string {
+	if target == p.root {
+		return "it is the root of the sweep"
+	}
+	return protection(target, p.protected, p.compiled)
+}
+
+func (p *planner) planSdFolder(sdFolder string) ([]PlanEntry, error) {
+	containingFolder := filepath.Dir(sdFolder)
+
+	dirEntries, err := os.ReadDir(sdFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	sdFiles := make([]fs.DirEntry, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() && filepath.Ext(dirEntry.Name()) == ".txt" {
+			sdFiles = append(sdFiles, dirEntry)
+		}
+	}
+
+	// Remove emptied sd folders
+	if len(sdFiles) == 0 {
+		return []PlanEntry{{Kind: EmptySdFolder, Path: sdFolder}}, nil
+	}
+
+	entries := make([]PlanEntry, 0, len(sdFiles))
+	marks := make([]ActionForFile, 0, len(sdFiles))
+	for _, dirEntry := range sdFiles {
+		sdFile := filepath.Join(sdFolder, dirEntry.Name())
+
+		if !sdFileNameRe.MatchString(dirEntry.Name()) {
+			entries = append(entries, PlanEntry{Kind: MalformedSdFile, Path: sdFile,
+				SdModTime: entryModTime(dirEntry), Reason: "not a legal name for an SD file"})
+			continue
+		}
+
+		// GetActionForFile stats the open SD file, so there's no need to
+		// stat it here as well.
+		actionForFile, err := GetActionForFile(sdFile, containingFolder)
+		var malformed *MalformedSdFileError
+		switch {
+		case errors.Is(err, ErrHostileSdFile):
+			entries = append(entries, PlanEntry{Kind: HostileSdFile, Path: sdFile, SdFile: sdFile,
+				SdModTime: entryModTime(dirEntry), Reason: err.Error()})
+			continue
+		case errors.As(err, &malformed):
+			entries = append(entries, PlanEntry{Kind: MalformedSdFile, Path: sdFile,
+				SdModTime: entryModTime(dirEntry), Reason: err.Error()})
+			continue
+		case err != nil:
+			// It may be fine once it can be read, so it is left alone.
+			entries = append(entries, PlanEntry{Kind: UnreadableSdFile, Path: sdFile,
+				SdModTime: entryModTime(dirEntry), Reason: err.Error()})
+			continue
+		}
+
+		marks = append(marks, actionForFile)
+	}
+
+	// A sync tool may leave conflicting copies of an SD file side by side.
+	// Only the winning mark for each file is acted on.
+	groups := groupByKey(marks, func(mark ActionForFile) string { return mark.File })
+	for _, group := range groups {
+		winner := group[0]
+		for i, actionForFile := range group {
+			markedAt := actionForFile.MarkTime()
+			if actionForFile.ExpiryTime(p.expiryMonths).Before(p.now) {
+				entries = append(entries, PlanEntry{Kind: ExpiredSdFile, Path: actionForFile.SdFile,
+					SdModTime: actionForFile.ModTime, MarkedAt: markedAt})
+				continue
+			}
+
+			entry := PlanEntry{Path: actionForFile.File, SdFile: actionForFile.SdFile,
+				SdModTime: actionForFile.ModTime, MarkedAt: markedAt, Pattern: actionForFile.Pattern}
+			if i == 0 && actionForFile.Action == Delete && p.now.Before(actionForFile.NotBefore) {
+				entry.Kind = NotYetDue
+				entry.Reason = fmt.Sprintf("not due until %s", actionForFile.NotBefore.Format(time.RFC3339))
+				entries = append(entries, entry)
+				continue
+			}
+			if i == 0 && len(actionForFile.Pattern) > 0 && actionForFile.Action == Delete {
+				patternEntries, err := p.planPattern(containingFolder, actionForFile, entry)
+				if err != nil {
+					return nil, err
+				}
+				entries = append(entries, patternEntries...)
+				continue
+			}
+
+			if i > 0 {
+				entry.Kind = SupersededSdFile
+				entry.Reason = fmt.Sprintf("superseded by '%s'", winner.SdFile)
+			} else if actionForFile.Action == Keep {
+				entry.Kind = Kept
+			} else if _, err := os.Lstat(actionForFile.File); os.IsNotExist(err) {
+				entry.Kind = AlreadyDeleted
+			} else if isExcluded(actionForFile.File, p.exclude) {
+				entry.Kind = Excluded
+			} else if escape := escapesFolder(actionForFile.File, containingFolder); len(escape) > 0 {
+				entry.Kind = HostileSdFile
+				entry.Reason = escape
+			} else if protection := p.protection(actionForFile.File); len(protection) > 0 {
+				entry.Kind = Protected
+				entry.Reason = protection
+			} else if mismatch := identityMismatch(actionForFile); len(mismatch) > 0 {
+				entry.Kind = ChangedTarget
+				entry.Reason = mismatch
+			} else {
+				entry.Kind = TargetDeletion
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+// planPattern plans the deletion of every match of a pattern mark. A mark
+// that matches nothing is planned as already deleted.
+func (p *planner) planPattern(containingFolder string, actionForFile ActionForFile, entry PlanEntry) ([]PlanEntry, error) {
+	matches, err := expandPattern(containingFolder, actionForFile.Pattern, actionForFile.Recursive, p.exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(matches) == 0 {
+		entry.Kind = AlreadyDeleted
+		return []PlanEntry{entry}, nil
+	}
+
+	entries := make([]PlanEntry, 0, len(matches))
+	for _, match := range matches {
+		entry.Kind = TargetDeletion
+		entry.Path = match
+		entry.Reason = ""
+		if escape := escapesFolder(match, containingFolder); len(escape) > 0 {
+			entry.Kind = HostileSdFile
+			entry.Reason = escape
+		} else if protection := p.protection(match); len(protection) > 0 {
+			entry.Kind = Protected
+			entry.Reason = protection
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func entryModTime(dirEntry fs.DirEntry) time.Time {
+	info, err := dirEntry.Info()
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func identityMismatch(actionForFile ActionForFile) string {
+	if actionForFile.Identity == nil {
+		return ""
+	}
+
+	mismatch, err := actionForFile.Identity.Mismatch(actionForFile.File)
+	if err != nil {
+		return err.Error()
+	}
+	return mismatch
+}
+
+// ExecutePlan removes every deleting entry of plan and returns the outcome of
+// every entry. Failures to remove a path are logged, recorded in the entry's
+// Error and do not stop the remaining deletions. If any failed, the error is
+// a *PartialFailureError listing them.
+func ExecutePlan(plan *SweepPlan, opts SweepOptions) ([]ReportEntry, error) {
+	return executePlan(context.Background(), plan, opts)
+}
+
+// executePlan is ExecutePlan, stopping before the next entry with ctx's
+// error when ctx is done.
+func executePlan(ctx context.Context, plan *SweepPlan, opts SweepOptions) ([]ReportEntry, error) {
+	logger := loggerOrDefault(opts.Logger)
+	results := make([]ReportEntry, 0, len(plan.Entries))
+	var failures []PathFailure
+
+	for _, entry := range plan.Entries {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+
+		result := ReportEntry{PlanEntry: entry}
+		if entry.Kind == UnreadableSdFile {
+			result.Error = entry.Reason
+			failures = append(failures, PathFailure{Path: entry.Path, Err: errors.New(entry.Reason)})
+		}
+		if !entry.Kind.Deletes() {
+			results = append(results, result)
+			continue
+		}
+
+		// A marked folder holding this path may have been deleted already.
+		if _, err := os.Lstat(entry.Path); os.IsNotExist(err) {
+			if entry.Kind == TargetDeletion {
+				result.Kind = AlreadyDeleted
+			}
+			logger.Debug("Already gone", entryAttrs(entry)...)
+			results = append(results, result)
+			continue
+		}
+
+		mode := RemoveMode
+		if entry.Kind == TargetDeletion {
+			mode = opts.DeleteMode
+
+			// The tree may have changed since the plan was made, so make
+			// sure the target is still reached without following symlinks.
+			if root := plan.rootOf(entry); len(root) > 0 {
+				if escape := escapesFolder(entry.Path, root); len(escape) > 0 {
+					err := fmt.Errorf("%w - refusing to delete as %s", ErrHostileSdFile, escape)
+					logger.Error("Refusing to delete", append(entryAttrs(entry), ErrorKey, err)...)
+					result.Error = err.Error()
+					failures = append(failures, PathFailure{Path: entry.Path, SdFile: entry.SdFile, Err: err})
+					results = append(results, result)
+					continue
+				}
+			}
+		}
+
+		var deleteMessage string
+		switch {
+		case opts.DryRun && mode == TrashMode:
+			deleteMessage = "Would move to the trash"
+		case opts.DryRun:
+			deleteMessage = "Would delete"
+		case mode == TrashMode:
+			deleteMessage = "Moving to the trash"
+		default:
+			deleteMessage = "Deleting"
+		}
+		logger.Info(deleteMessage, entryAttrs(entry)...)
+
+		if opts.Reporter != nil {
+			result.BytesFreed = PathSize(entry.Path)
+		}
+
+		if opts.DryRun {
+			result.Deleted = true
+			results = append(results, result)
+			continue
+		}
+
+		var err error
+		if entry.Kind == EmptySdFolder {
+			// SD files may have arrived since the plan was made, so only
+			// a folder that is still empty is removed.
+			err = os.Remove(entry.Path)
+		} else {
+			err = removeTarget(entry.Path, mode)
+		}
+		if err != nil {
+			logger.Error("Failed to delete", append(entryAttrs(entry), ErrorKey, err)...)
+			result.BytesFreed = 0
+			result.Error = err.Error()
+			failures = append(failures, PathFailure{Path: entry.Path, SdFile: entry.SdFile, Err: err})
+		} else {
+			result.Deleted = true
+		}
+		results = append(results, result)
+	}
+
+	if len(failures) > 0 {
+		return results, &PartialFailureError{Failures: failures}
+	}
+	return results, nil
+}
+
+// rootOf is the root of the plan that entry is in or, failing that, the
+// folder of its SD file.
+func (plan *SweepPlan) rootOf(entry PlanEntry) string {
+	for _, root := range plan.Roots {
+		if isWithin(entry.Path, root) {
+			return root
+		}
+	}
+	if len(entry.SdFile) > 0 {
+		return filepath.Dir(filepath.Dir(entry.SdFile))
+	}
+	return ""
+}
+
+// entryAttrs are the log attributes for entry. Entries for SD files and SD
+// folders have no target.
+func entryAttrs(entry PlanEntry) []any {
+	switch entry.Kind {
+	case ExpiredSdFile, MalformedSdFile, UnreadableSdFile:
+		return []any{SdFileKey, entry.Path}
+	case EmptySdFolder:
+		return []any{"sd_folder", entry.Path}
+	case HostileSdFile:
+		if entry.Path == entry.SdFile {
+			return []any{SdFileKey, entry.Path}
+		}
+	}
+
+	attrs := []any{TargetKey, entry.Path}
+	if len(entry.SdFile) > 0 {
+		attrs = append(attrs, SdFileKey, entry.SdFile)
+	}
+	if len(entry.Pattern) > 0 {
+		attrs = append(attrs, "pattern", entry.Pattern)
+	}
+	return attrs
+}
+
+// logPlan logs the decision made for each entry of plan. Entries that
+// change nothing are logged at debug level.
+func logPlan(plan *SweepPlan, logger *slog.Logger) {
+	for _, entry := range plan.Entries {
+		attrs := entryAttrs(entry)
+		switch entry.Kind {
+		case TargetDeletion:
+			logger.Info("Adding to the delete list", attrs...)
+		case ExpiredSdFile:
+			logger.Info("Adding old SD file to the delete list", append(attrs, "marked", entry.MarkedAt)...)
+		case MalformedSdFile:
+			logger.Info("Adding malformed SD file to the delete list", append(attrs, "reason", entry.Reason)...)
+		case EmptySdFolder:
+			logger.Info("Adding empty SD folder to the delete list", attrs...)
+		case AlreadyDeleted:
+			logger.Debug("Already deleted", attrs...)
+		case Kept:
+			logger.Debug("Keeping", attrs...)
+		case Excluded:
+			logger.Debug("Not deleting excluded target", attrs...)
+		case ChangedTarget:
+			logger.Info("Not deleting changed target", append(attrs, "reason", entry.Reason)...)
+		case SupersededSdFile:
+			logger.Info("Ignoring superseded SD file", append(attrs, "reason", entry.Reason)...)
+		case HostileSdFile:
+			logger.Warn("Ignoring hostile SD file", append(attrs, "reason", entry.Reason)...)
+		case Protected:
+			logger.Warn("Not deleting protected path", append(attrs, "reason", entry.Reason)...)
+		case Skipped:
+			logger.Info("Skipping", attrs...)
+		case NotYetDue:
+			logger.Debug("Not deleting yet", append(attrs, "reason", entry.Reason)...)
+		case UnreadableSdFile:
+			logger.Error("Unable to read SD file", append(attrs, ErrorKey, entry.Reason)...)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package sdlib
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrProtectedPath is wrapped by errors about marking a protected path for
+// deletion.
+var ErrProtectedPath = errors.New("protected path")
+
+// compileProtected splits each protected pattern into its path segments.
+// Protected patterns are globs matched against whole paths, where ** matches
+// any number of folders, e.g. **/.git or ~/Documents/tax/**. A leading ~ is
+// the home folder, and a pattern that isn't absolute can match anywhere, so
+// .git is the same as **/.git.
+func compileProtected(patterns []string) [][]string {
+	compiled := make([][]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		if pattern == "~" || strings.HasPrefix(pattern, "~/") || strings.HasPrefix(pattern, `~\`) {
+			if home, err := os.UserHomeDir(); err == nil {
+				pattern = home + pattern[1:]
+			}
+		}
+
+		pattern = filepath.ToSlash(filepath.Clean(pattern))
+		if !filepath.IsAbs(filepath.FromSlash(pattern)) && !strings.HasPrefix(pattern, "**") {
+			pattern = "**/" + pattern
+		}
+		compiled = append(compiled, strings.Split(pattern, "/"))
+	}
+	return compiled
+}
+
+func splitPath(p string) []string {
+	return strings.Split(filepath.ToSlash(filepath.Clean(p)), "/")
+}
+
+// matchSegments reports whether the segments of a path match those of a
+// protected pattern.
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(segments) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pattern[0], segments[0]); !matched {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
+
+// protectedBy returns the pattern of protected that matches p, or "".
+func protectedBy(p string, patterns []string, protected [][]string) string {
+	segments := splitPath(p)
+	for i, pattern := range protected {
+		if matchSegments(pattern, segments) {
+			return patterns[i]
+		}
+	}
+	return ""
+}
+
+// protection explains why deleting target would remove a protected path,
+// or is "" if it wouldn't. A folder is protected if anything in it is.
+func protection(target string, patterns []string, protected [][]string) string {
+	if len(protected) == 0 {
+		return ""
+	}
+
+	if pattern := protectedBy(target, patterns, protected); len(pattern) > 0 {
+		return fmt.Sprintf("it matches the protected pattern '%s'", pattern)
+	}
+
+	var reason string
+	filepath.WalkDir(target, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == target {
+			return nil
+		}
+		if pattern := protectedBy(p, patterns, protected); len(pattern) > 0 {
+			reason = fmt.Sprintf("it contains '%s', which matches the protected pattern '%s'", p, pattern)
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return reason
+}
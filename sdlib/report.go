@@ -0,0 +1,346 @@
+package sdlib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ReportVersion is the version of the JSON report schema. It is bumped
+// whenever a field is renamed or removed.
+const ReportVersion = 1
+
+// ReportEntry is the outcome of executing a single PlanEntry.
+type ReportEntry struct {
+	PlanEntry
+	// Deleted is true if Path was removed, or would have been in a dry run.
+	Deleted bool `json:"deleted"`
+	// BytesFreed is the size of Path at the time it was removed.
+	BytesFreed int64  `json:"bytesFreed"`
+	Error      string `json:"error,omitempty"`
+}
+
+// ReportError is a failure that is not tied to a single plan entry.
+type ReportError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// ReportTotals summarises a root or a whole sweep.
+type ReportTotals struct {
+	SdFiles    int   `json:"sdFiles"`
+	Deleted    int   `json:"deleted"`
+	Kept       int   `json:"kept"`
+	Failed     int   `json:"failed"`
+	BytesFreed int64 `json:"bytesFreed"`
+}
+
+func (t *ReportTotals) add(other ReportTotals) {
+	t.SdFiles += other.SdFiles
+	t.Deleted += other.Deleted
+	t.Kept += other.Kept
+	t.Failed += other.Failed
+	t.BytesFreed += other.BytesFreed
+}
+
+// RootReport is the outcome of sweeping one root. While a root is swept
+// its entries are passed to the Reporter as they are executed rather than
+// collected, so Entries is empty in the RootReport given to ReportRoot.
+type RootReport struct {
+	Root    string        `json:"root"`
+	DryRun  bool          `json:"dryRun"`
+	Entries []ReportEntry `json:"entries"`
+	Errors  []ReportError `json:"errors"`
+	Totals  ReportTotals  `json:"totals"`
+
+	// reporter receives the entries as they are added and reportErr is the
+	// first error it returned.
+	reporter  Reporter
+	reportErr error
+}
+
+func newRootReport(root string, dryRun bool) RootReport {
+	return RootReport{
+		Root:    root,
+		DryRun:  dryRun,
+		Entries: make([]ReportEntry, 0),
+		Errors:  make([]ReportError, 0),
+	}
+}
+
+func (r *RootReport) addEntries(entries []ReportEntry) {
+	if r.reporter != nil && len(entries) > 0 && r.reportErr == nil {
+		r.reportErr = r.reporter.ReportEntries(r.Root, entries)
+	}
+
+	for _, entry := range entries {
+		if entry.Kind != EmptySdFolder {
+			r.Totals.SdFiles++
+		}
+		if entry.Kind == Kept {
+			r.Totals.Kept++
+		}
+		if entry.Deleted {
+			r.Totals.Deleted++
+			r.Totals.BytesFreed += entry.BytesFreed
+		}
+		if len(entry.Error) > 0 {
+			r.Totals.Failed++
+		}
+	}
+}
+
+func (r *RootReport) addError(path string, err error) {
+	r.Errors = append(r.Errors, ReportError{Path: path, Error: err.Error()})
+	r.Totals.Failed++
+}
+
+// SweepReport is the whole JSON report for a sweep of one or more roots.
+type SweepReport struct {
+	Version int          `json:"version"`
+	Roots   []RootReport `json:"roots"`
+	Totals  ReportTotals `json:"totals"`
+}
+
+// Reporter receives the outcome of each root as it is swept. Roots may be
+// swept in parallel, so its methods must be safe to call concurrently.
+type Reporter interface {
+	// ReportEntries is called with each batch of entries as it is executed.
+	ReportEntries(root string, entries []ReportEntry) error
+	// ReportRoot is called once a root has been swept, with its errors and
+	// totals.
+	ReportRoot(root RootReport) error
+	// Close writes anything still buffered.
+	Close() error
+}
+
+// NewReporter returns a Reporter for the named format, "json" or "jsonl".
+func NewReporter(format string, w io.Writer) (Reporter, error) {
+	switch format {
+	case "json":
+		return &jsonReporter{w: w, spools: make(map[string]*entrySpool)}, nil
+	case "jsonl":
+		return &jsonLinesReporter{enc: json.NewEncoder(w)}, nil
+	}
+	return nil, fmt.Errorf("unknown report format '%s'", format)
+}
+
+// jsonReporter writes a single SweepReport on Close. So that a large sweep
+// isn't held in memory, each root's entries are spooled to a temporary file
+// until then.
+type jsonReporter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	roots  []RootReport
+	totals ReportTotals
+	spools map[string]*entrySpool
+}
+
+// entrySpool holds a root's entries, one compact JSON object per line.
+type entrySpool struct {
+	file  *os.File
+	count int
+}
+
+func (r *jsonReporter) ReportEntries(root string, entries []ReportEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	spool, ok := r.spools[root]
+	if !ok {
+		file, err := os.CreateTemp("", "staydeleted-report-*.jsonl")
+		if err != nil {
+			return err
+		}
+		spool = &entrySpool{file: file}
+		r.spools[root] = spool
+	}
+
+	enc := json.NewEncoder(spool.file)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+		spool.count++
+	}
+	return nil
+}
+
+func (r *jsonReporter) ReportRoot(root RootReport) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.roots = append(r.roots, root)
+	r.totals.add(root.Totals)
+	return nil
+}
+
+// Close writes the report in the layout json.MarshalIndent would give it,
+// copying each root's entries from its spool, and removes the spools.
+func (r *jsonReporter) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	defer r.removeSpools()
+
+	w := bufio.NewWriter(r.w)
+	fmt.Fprintf(w, "{\n  \"version\": %d,\n  \"roots\": [", ReportVersion)
+	for i, root := range r.roots {
+		if i > 0 {
+			w.WriteString(",")
+		}
+		if err := r.writeRoot(w, root); err != nil {
+			return err
+		}
+	}
+	if len(r.roots) > 0 {
+		w.WriteString("\n  ")
+	}
+
+	totals, err := json.MarshalIndent(r.totals, "  ", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "],\n  \"totals\": %s\n}\n", totals)
+	return w.Flush()
+}
+
+func (r *jsonReporter) writeRoot(w *bufio.Writer, root RootReport) error {
+	const indent = "      "
+
+	name, err := json.Marshal(root.Root)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\n    {\n%s\"root\": %s,\n%s\"dryRun\": %t,\n%s\"entries\": [",
+		indent, name, indent, root.DryRun, indent)
+
+	if spool, ok := r.spools[root.Root]; ok && spool.count > 0 {
+		if _, err := spool.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		lines := bufio.NewScanner(spool.file)
+		lines.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		for i := 0; lines.Scan(); i++ {
+			if i > 0 {
+				w.WriteString(",")
+			}
+			var entry bytes.Buffer
+			if err := json.Indent(&entry, lines.Bytes(), indent+"  ", "  "); err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "\n%s  %s", indent, entry.Bytes())
+		}
+		if err := lines.Err(); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "\n%s", indent)
+	}
+
+	errs, err := json.MarshalIndent(root.Errors, indent, "  ")
+	if err != nil {
+		return err
+	}
+	totals, err := json.MarshalIndent(root.Totals, indent, "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "],\n%s\"errors\": %s,\n%s\"totals\": %s\n    }", indent, errs, indent, totals)
+	return nil
+}
+
+func (r *jsonReporter) removeSpools() {
+	for root, spool := range r.spools {
+		spool.file.Close()
+		os.Remove(spool.file.Name())
+		delete(r.spools, root)
+	}
+}
+
+// jsonLinesReporter writes one object per line: an "entry" line for each
+// entry as it is executed, then an "error" line for each of a root's errors
+// and a "root" line with its totals once it is swept, and a final "totals"
+// line on Close. The entries of roots swept in parallel may be interleaved.
+type jsonLinesReporter struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	totals ReportTotals
+}
+
+type jsonLineHeader struct {
+	Version int    `json:"version"`
+	Type    string `json:"type"`
+	Root    string `json:"root,omitempty"`
+}
+
+type jsonEntryLine struct {
+	jsonLineHeader
+	ReportEntry
+}
+
+type jsonErrorLine struct {
+	jsonLineHeader
+	ReportError
+}
+
+type jsonTotalsLine struct {
+	jsonLineHeader
+	Totals ReportTotals `json:"totals"`
+}
+
+func (r *jsonLinesReporter) ReportEntries(root string, entries []ReportEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, entry := range entries {
+		err := r.enc.Encode(jsonEntryLine{jsonLineHeader{ReportVersion, "entry", root}, entry})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *jsonLinesReporter) ReportRoot(root RootReport) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, rootErr := range root.Errors {
+		err := r.enc.Encode(jsonErrorLine{jsonLineHeader{ReportVersion, "error", root.Root}, rootErr})
+		if err != nil {
+			return err
+		}
+	}
+
+	r.totals.add(root.Totals)
+	return r.enc.Encode(jsonTotalsLine{jsonLineHeader{ReportVersion, "root", root.Root}, root.Totals})
+}
+
+func (r *jsonLinesReporter) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.enc.Encode(jsonTotalsLine{jsonLineHeader{ReportVersion, "totals", ""}, r.totals})
+}
+
+// PathSize is the total size of the regular files at or below path.
+func PathSize(path string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
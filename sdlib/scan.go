@@ -0,0 +1,143 @@
+package sdlib
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// isExcluded reports whether the base name of path matches any of the
+// exclude patterns.
+func isExcluded(path string, exclude []string) bool {
+	name := filepath.Base(path)
+	for _, pattern := range exclude {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultScanWorkers is used when SweepOptions.ScanWorkers isn't set.
+// Scanning is bound by I/O rather than CPU, so this is more than the
+// number of cores on most machines.
+const defaultScanWorkers = 8
+
+func scanWorkers(opts SweepOptions) int {
+	if opts.ScanWorkers < 1 {
+		return defaultScanWorkers
+	}
+	return opts.ScanWorkers
+}
+
+// findSdFolders returns every SD folder under root in lexical order,
+// reading up to workers directories at a time. Only the entry types
+// returned when reading a directory are used, so files in the tree are
+// never stat'ed. Symbolic links are not followed. The first error reading
+// a directory stops the scan.
+func findSdFolders(root string, workers int, exclude []string) ([]string, error) {
+	var mu sync.Mutex
+	cond := sync.NewCond(&mu)
+
+	// The queue is used as a stack so that it stays small on deep trees.
+	queue := []string{root}
+	active := 0
+	sdFolders := make([]string, 0)
+	var firstErr error
+
+	worker := func() {
+		for {
+			mu.Lock()
+			for len(queue) == 0 && active > 0 {
+				cond.Wait()
+			}
+			if len(queue) == 0 {
+				mu.Unlock()
+				return
+			}
+			dir := queue[len(queue)-1]
+			queue = queue[:len(queue)-1]
+			active++
+			mu.Unlock()
+
+			subdirs, found, err := scanDir(dir, exclude)
+
+			mu.Lock()
+			active--
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			if firstErr != nil {
+				queue = queue[:0]
+			} else {
+				queue = append(queue, subdirs...)
+				sdFolders = append(sdFolders, found...)
+			}
+			mu.Unlock()
+			cond.Broadcast()
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker()
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	sort.Strings(sdFolders)
+	return sdFolders, nil
+}
+
+// scanDir reads dir and splits its subdirectories into the SD folder, if
+// any, and the others to scan, leaving out excluded ones.
+func scanDir(dir string, exclude []string) (subdirs []string, sdFolders []string, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		if entry.Name() == SdFolderName {
+			sdFolders = append(sdFolders, path)
+		} else if !isExcluded(path, exclude) {
+			subdirs = append(subdirs, path)
+		}
+	}
+
+	return subdirs, sdFolders, nil
+}
+
+// forEachParallel calls fn for every index below n, up to workers at a time.
+func forEachParallel(n, workers int, fn func(i int)) {
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
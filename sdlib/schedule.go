@@ -0,0 +1,173 @@
+package sdlib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule says when a sweep should next run.
+type Schedule interface {
+	// Next returns the first run time after the given time.
+	Next(after time.Time) time.Time
+}
+
+// intervalSchedule runs a fixed time after the previous run.
+type intervalSchedule time.Duration
+
+func (s intervalSchedule) Next(after time.Time) time.Time {
+	return after.Add(time.Duration(s))
+}
+
+// ParseSchedule parses an interval such as "6h" or "@every 6h", one of
+// @hourly, @daily, @weekly or @monthly, or a five field cron expression
+// such as "30 3 * * 1-5".
+func ParseSchedule(scheduleStr string) (Schedule, error) {
+	scheduleStr = strings.TrimSpace(scheduleStr)
+
+	switch scheduleStr {
+	case "@hourly":
+		scheduleStr = "0 * * * *"
+	case "@daily", "@midnight":
+		scheduleStr = "0 0 * * *"
+	case "@weekly":
+		scheduleStr = "0 0 * * 0"
+	case "@monthly":
+		scheduleStr = "0 0 1 * *"
+	}
+
+	if interval, found := strings.CutPrefix(scheduleStr, "@every "); found {
+		scheduleStr = strings.TrimSpace(interval)
+	}
+	if interval, err := time.ParseDuration(scheduleStr); err == nil {
+		if interval <= 0 {
+			return nil, fmt.Errorf("the interval in schedule '%s' must be positive", scheduleStr)
+		}
+		return intervalSchedule(interval), nil
+	}
+
+	return parseCron(scheduleStr)
+}
+
+// cronSchedule is a parsed five field cron expression. Each field is the
+// set of values it matches.
+type cronSchedule struct {
+	minute, hour, dom, month, dow map[int]bool
+	// As in cron, when both the day of the month and the day of the week
+	// are restricted, a day matching either runs.
+	domRestricted, dowRestricted bool
+}
+
+func parseCron(cronStr string) (*cronSchedule, error) {
+	fields := strings.Fields(cronStr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("unable to convert '%s' to a schedule", cronStr)
+	}
+
+	var s cronSchedule
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	// Sunday can be 0 or 7.
+	if s.dow[7] {
+		s.dow[0] = true
+	}
+	s.domRestricted = fields[2] != "*"
+	s.dowRestricted = fields[4] != "*"
+
+	return &s, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges such as
+// 1-5, and steps such as */15 or 0-30/10.
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		rangeStr, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("unable to convert '%s' to a cron step", part)
+			}
+		}
+
+		lo, hi := min, max
+		if rangeStr != "*" {
+			loStr, hiStr, isRange := strings.Cut(rangeStr, "-")
+			var err error
+			if lo, err = strconv.Atoi(loStr); err != nil {
+				return nil, fmt.Errorf("unable to convert '%s' to a cron field", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(hiStr); err != nil {
+					return nil, fmt.Errorf("unable to convert '%s' to a cron field", part)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("'%s' is out of the range %d-%d", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom[t.Day()]
+	dowMatch := s.dow[int(t.Weekday())]
+	if s.domRestricted && s.dowRestricted {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+func (s *cronSchedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+
+	// Give up after five years, which is enough for any day to come round.
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !s.month[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.hour[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.minute[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
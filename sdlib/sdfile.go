@@ -0,0 +1,252 @@
+package sdlib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SD files were originally two lines, the base name of the marked file and
+// the action. Since version 2 they start with a header line naming the
+// format and its version, followed by "key: value" fields. Unknown fields
+// are ignored so that older versions of the tool can read newer files.
+const (
+	LegacySdFileVersion = 1
+	SdFileVersion       = 2
+	sdFileHeader        = "staydeleted"
+	sdTimeFormat        = time.RFC3339
+)
+
+// SdRecord is the parsed contents of an SD file.
+type SdRecord struct {
+	// Version is the format version of the SD file.
+	Version int
+	// Name is the base name of the marked file. It is empty for pattern marks.
+	Name string
+	// Pattern is a glob matched against base names in the containing
+	// folder, and in all its subfolders if Recursive is set.
+	Pattern   string
+	Recursive bool
+	Action    Action
+	// MarkedAt is when the mark was made. It is zero for legacy SD files,
+	// which only have their modification time to go by.
+	MarkedAt time.Time
+	// Replica identifies the machine that made the mark.
+	Replica string
+	// Clock is a logical clock, one more than the clock of the mark it
+	// replaced, so that a mark made after seeing another always wins.
+	Clock int
+	// Identity describes the marked file when it was marked for deletion,
+	// if it existed then.
+	Identity *TargetIdentity
+	// ExpiresAt, if set, is when the mark expires in place of the sweep's
+	// expiry.
+	ExpiresAt time.Time
+	// NotBefore, if set, is when a mark for deletion becomes due. Until
+	// then sweeps leave the target alone.
+	NotBefore time.Time
+}
+
+// MalformedSdFileError is returned for an SD file whose contents can't be
+// parsed, as opposed to one that couldn't be read.
+type MalformedSdFileError struct {
+	Err error
+}
+
+func (e *MalformedSdFileError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *MalformedSdFileError) Unwrap() error {
+	return e.Err
+}
+
+// parseSdFile reads an SD file from r. Errors reading r are returned as
+// they are and errors in its contents as a *MalformedSdFileError.
+func parseSdFile(r io.Reader) (SdRecord, error) {
+	lines := make([]string, 0, 4)
+	input := bufio.NewScanner(r)
+	for input.Scan() {
+		lines = append(lines, input.Text())
+	}
+	if err := input.Err(); err != nil {
+		return SdRecord{}, err
+	}
+
+	record, err := parseSdFileLines(lines)
+	if err != nil {
+		return SdRecord{}, &MalformedSdFileError{Err: err}
+	}
+	return record, nil
+}
+
+func parseSdFileLines(lines []string) (SdRecord, error) {
+	if len(lines) == 0 {
+		return SdRecord{}, fmt.Errorf("empty SD file")
+	}
+
+	version, isVersioned := parseSdFileHeader(lines[0])
+	// A legacy SD file for a file that happens to be named like the
+	// header still has the bare action on its second line.
+	if !isVersioned || (len(lines) > 1 && isActionString(lines[1])) {
+		return parseLegacySdFile(lines)
+	}
+
+	record := SdRecord{Version: version}
+	for _, line := range lines[1:] {
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			return SdRecord{}, fmt.Errorf("malformed SD file field '%s'", line)
+		}
+		value = strings.TrimPrefix(value, " ")
+
+		var err error
+		switch key {
+		case "name":
+			record.Name = value
+		case "pattern":
+			record.Pattern = value
+		case "recursive":
+			record.Recursive, err = strconv.ParseBool(value)
+		case "action":
+			record.Action, err = getActionForString(value)
+		case "marked":
+			record.MarkedAt, err = time.Parse(sdTimeFormat, value)
+		case "expires":
+			record.ExpiresAt, err = time.Parse(sdTimeFormat, value)
+		case "after":
+			record.NotBefore, err = time.Parse(sdTimeFormat, value)
+		case "replica":
+			record.Replica = value
+		case "clock":
+			record.Clock, err = strconv.Atoi(value)
+		case "target-type":
+			identity(&record).IsDir = value == "dir"
+		case "target-size":
+			identity(&record).Size, err = strconv.ParseInt(value, 10, 64)
+		case "target-modified":
+			identity(&record).ModTime, err = time.Parse(time.RFC3339Nano, value)
+		case "target-sha256":
+			identity(&record).SHA256 = value
+		}
+		if err != nil {
+			return SdRecord{}, err
+		}
+	}
+
+	if len(record.Name) == 0 && len(record.Pattern) == 0 {
+		return SdRecord{}, fmt.Errorf("SD file has no name or pattern")
+	}
+	if len(record.Name) > 0 {
+		if err := validateName(record.Name); err != nil {
+			return SdRecord{}, err
+		}
+	}
+	if len(record.Pattern) > 0 {
+		if strings.ContainsAny(record.Pattern, "/\\\x00") {
+			return SdRecord{}, fmt.Errorf("%w - pattern '%s' contains a path separator",
+				ErrHostileSdFile, record.Pattern)
+		}
+		if err := validatePattern(record.Pattern); err != nil {
+			return SdRecord{}, err
+		}
+	}
+	if record.Action == NoAction {
+		return SdRecord{}, fmt.Errorf("SD file has no action")
+	}
+
+	return record, nil
+}
+
+func identity(record *SdRecord) *TargetIdentity {
+	if record.Identity == nil {
+		record.Identity = &TargetIdentity{}
+	}
+	return record.Identity
+}
+
+func parseSdFileHeader(line string) (int, bool) {
+	format, versionStr, found := strings.Cut(line, " ")
+	if !found || format != sdFileHeader {
+		return 0, false
+	}
+
+	version, err := strconv.Atoi(versionStr)
+	if err != nil || version < SdFileVersion {
+		return 0, false
+	}
+	return version, true
+}
+
+func parseLegacySdFile(lines []string) (SdRecord, error) {
+	var actStr string
+	if len(lines) > 1 {
+		actStr = lines[1]
+	}
+
+	action, err := getActionForString(actStr)
+	if err != nil {
+		return SdRecord{}, err
+	}
+
+	if err := validateName(lines[0]); err != nil {
+		return SdRecord{}, err
+	}
+
+	return SdRecord{Version: LegacySdFileVersion, Name: lines[0], Action: action}, nil
+}
+
+func isActionString(actStr string) bool {
+	_, err := getActionForString(actStr)
+	return err == nil
+}
+
+func writeSdFile(w io.Writer, record SdRecord) error {
+	_, err := fmt.Fprintf(w, "%s %d\n", sdFileHeader, SdFileVersion)
+	if err != nil {
+		return err
+	}
+
+	if len(record.Pattern) > 0 {
+		_, err = fmt.Fprintf(w, "pattern: %s\nrecursive: %t\n", record.Pattern, record.Recursive)
+	} else {
+		_, err = fmt.Fprintf(w, "name: %s\n", record.Name)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "action: %s\nmarked: %s\nreplica: %s\nclock: %d\n",
+		getStringForAction(record.Action),
+		record.MarkedAt.UTC().Format(sdTimeFormat),
+		record.Replica,
+		record.Clock)
+	if err == nil && !record.ExpiresAt.IsZero() {
+		_, err = fmt.Fprintf(w, "expires: %s\n", record.ExpiresAt.UTC().Format(sdTimeFormat))
+	}
+	if err == nil && !record.NotBefore.IsZero() {
+		_, err = fmt.Fprintf(w, "after: %s\n", record.NotBefore.UTC().Format(sdTimeFormat))
+	}
+	if err != nil || record.Identity == nil {
+		return err
+	}
+
+	if record.Identity.IsDir {
+		_, err = fmt.Fprintf(w, "target-type: dir\n")
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "target-type: file\ntarget-size: %d\ntarget-modified: %s\n",
+		record.Identity.Size, record.Identity.ModTime.UTC().Format(time.RFC3339Nano))
+	if err == nil && len(record.Identity.SHA256) > 0 {
+		_, err = fmt.Fprintf(w, "target-sha256: %s\n", record.Identity.SHA256)
+	}
+	return err
+}
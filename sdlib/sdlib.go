@@ -2,14 +2,13 @@ package sdlib
 
 import (
 	"bufio"
+	"context"
 	"crypto/md5"
 	"errors"
 	"fmt"
-	"io"
-	"io/fs"
+	"log/slog"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
 	"time"
 )
@@ -24,11 +23,71 @@ const (
 
 type ActionForFile struct {
 	SdFile, File string
-	Action       Action
+	// ModTime is the modification time of the SD file.
+	ModTime time.Time
+	SdRecord
 }
 
 const SdFolderName = ".stay-deleted"
 
+// ReplicaID is recorded in each mark to break ties between marks made on
+// different machines. It defaults to the host name.
+var ReplicaID = defaultReplicaID()
+
+func defaultReplicaID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return hostname
+}
+
+// SweepOptions controls how SweepDirectory and SweepFrom behave.
+type SweepOptions struct {
+	// ExpiryMonths is the number of months before SD files expire.
+	ExpiryMonths int
+	// Logger receives the sweep's log records, with progress for every SD
+	// folder and file examined at debug level. Nil uses slog's default.
+	Logger *slog.Logger
+	// DryRun reports the delete list without removing anything.
+	DryRun bool
+	// DeleteMode is how targets marked for deletion are removed. SD files
+	// and empty SD folders are always removed outright.
+	DeleteMode DeleteMode
+	// Reporter, if set, receives a structured report of each root swept.
+	Reporter Reporter
+	// Jobs is the number of roots SweepFrom sweeps at the same time.
+	Jobs int
+	// ScanWorkers is the number of directories each sweep reads at the
+	// same time. Zero uses a default suited to most disks.
+	ScanWorkers int
+	// Exclude are glob patterns matched against base names. Matching
+	// folders are not swept and matching targets are not deleted.
+	Exclude []string
+	// Protected are patterns for paths that are never deleted, whatever
+	// their SD files say, such as **/.git. A target holding a protected
+	// path isn't deleted either. The root being swept is always protected.
+	Protected []string
+	// QueueSize is the most SD folders SweepDirectory holds between
+	// finding them and executing them. Zero uses a default.
+	QueueSize int
+	// ExternalLocks are files, such as a sync script's lock file, whose
+	// presence means the sweep must not run.
+	ExternalLocks []string
+	// LockWait is how long to wait for a held lock before skipping the sweep.
+	LockWait time.Duration
+	// StaleLockAge is how old a lock from another host must be to be
+	// ignored. Zero uses DefaultStaleLockAge.
+	StaleLockAge time.Duration
+	// Limits stop a sweep that would delete too much of a root. When any
+	// are set, each root is planned in full before anything is deleted.
+	Limits DeletionLimits
+	// Review, if set, is asked about each target a sweep would delete,
+	// after the whole root has been planned. Targets it doesn't approve
+	// are skipped and left for the next sweep.
+	Review func(entry PlanEntry) bool
+}
+
 func GetActionForBool(keep bool) Action {
 	if keep {
 		return Keep
@@ -36,6 +95,26 @@ func GetActionForBool(keep bool) Action {
 	return Delete
 }
 
+func (a Action) String() string {
+	if a == NoAction {
+		return "none"
+	}
+	return getStringForAction(a)
+}
+
+func (a Action) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Action) UnmarshalText(text []byte) error {
+	action, err := getActionForString(string(text))
+	if err != nil {
+		return err
+	}
+	*a = action
+	return nil
+}
+
 func getStringForAction(action Action) string {
 	if action == Keep {
 		return "keep"
@@ -59,18 +138,14 @@ func GetSdFolder(file string) (string, error) {
 	attemptedAbsSdFolder := filepath.Join(dir, SdFolderName)
 	absSdFolder, err := filepath.Abs(attemptedAbsSdFolder)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to find the absolute path of '%v'!",
-			attemptedAbsSdFolder)
-		return "", err
-	} else {
-		return absSdFolder, nil
+		return "", fmt.Errorf("unable to find the absolute path of '%v' - %w", attemptedAbsSdFolder, err)
 	}
+	return absSdFolder, nil
 }
 
 func GetSdFile(file string) (string, error) {
 	sdFolder, err := GetSdFolder(file)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to get sd folder for '%v'!", file)
 		return "", err
 	}
 
@@ -79,65 +154,164 @@ func GetSdFile(file string) (string, error) {
 	return filepath.Join(sdFolder, fmt.Sprintf("%x.txt", md5.Sum(data))), nil
 }
 
-func GetActionForFile(sdFileName, containingFolder string, errWriter io.Writer) (ActionForFile, error) {
+func GetActionForFile(sdFileName, containingFolder string) (ActionForFile, error) {
 	sdFile, err := os.Open(sdFileName)
+	if err != nil {
+		return ActionForFile{}, err
+	}
 	defer sdFile.Close()
 
+	record, err := parseSdFile(sdFile)
 	if err != nil {
-		fmt.Fprintf(errWriter, "%v\n", err)
-		return ActionForFile{"", "", NoAction}, err
+		return ActionForFile{}, err
 	}
 
-	input := bufio.NewScanner(sdFile)
-	input.Scan()
-	fileToProcessName := filepath.Join(containingFolder, input.Text())
-	input.Scan()
-	action, err := getActionForString(input.Text())
+	sdStat, err := sdFile.Stat()
 	if err != nil {
-		fmt.Fprintf(errWriter, "%v\n", err)
-		return ActionForFile{"", "", NoAction}, err
+		return ActionForFile{}, err
+	}
+
+	fileToProcessName := filepath.Join(containingFolder, record.Name)
+	if len(record.Pattern) > 0 {
+		fileToProcessName = filepath.Join(containingFolder, record.Pattern)
 	}
 
-	return ActionForFile{sdFileName, fileToProcessName, action}, nil
+	return ActionForFile{
+		SdFile:   sdFileName,
+		File:     fileToProcessName,
+		ModTime:  sdStat.ModTime(),
+		SdRecord: record,
+	}, nil
+}
+
+// MarkTime is when the mark was made, falling back to the SD file's
+// modification time for legacy SD files.
+func (a ActionForFile) MarkTime() time.Time {
+	if a.MarkedAt.IsZero() {
+		return a.ModTime
+	}
+	return a.MarkedAt
+}
+
+// ExpiryTime is when the mark expires, given the sweep's expiry. A mark's
+// own expiry takes precedence, and a mark that isn't due yet doesn't start
+// to age until it is.
+func (a ActionForFile) ExpiryTime(expiryMonths int) time.Time {
+	if !a.ExpiresAt.IsZero() {
+		return a.ExpiresAt
+	}
+
+	from := a.MarkTime()
+	if a.NotBefore.After(from) {
+		from = a.NotBefore
+	}
+	return from.AddDate(0, expiryMonths, 0)
+}
+
+// MarkOptions controls what MarkFile records in the SD file.
+type MarkOptions struct {
+	// Hash records a hash of the contents of files marked for deletion, so
+	// that sweep can recognise them even if their modification time is lost.
+	Hash bool
+	// Logger receives a record of each mark made. Nil uses slog's default.
+	Logger *slog.Logger
+	// Protected are patterns for paths that may not be marked for
+	// deletion, as for SweepOptions.Protected.
+	Protected []string
+	// ExpiresAt, if set, is when the mark expires, overriding the expiry
+	// given to sweeps.
+	ExpiresAt time.Time
+	// NotBefore, if set, is when a mark for deletion becomes due.
+	NotBefore time.Time
+}
+
+// checkTimes makes sure that the times in opts make sense for action.
+func (opts MarkOptions) checkTimes(action Action) error {
+	if !opts.NotBefore.IsZero() && action != Delete {
+		return fmt.Errorf("only marks for deletion can have a time to delete after")
+	}
+	if !opts.ExpiresAt.IsZero() && !opts.NotBefore.IsZero() && !opts.ExpiresAt.After(opts.NotBefore) {
+		return fmt.Errorf("the mark would expire at %s, before it is due at %s",
+			opts.ExpiresAt.Format(time.RFC3339), opts.NotBefore.Format(time.RFC3339))
+	}
+	return nil
 }
 
 func SetActionForFile(fileName string, action Action) error {
+	return MarkFile(fileName, action, MarkOptions{})
+}
+
+// MarkFile writes the SD file for fileName. Files marked for deletion that
+// exist have their identity recorded so that sweep only deletes that file
+// and not a new one created later with the same name. Protected paths can't
+// be marked for deletion.
+func MarkFile(fileName string, action Action, opts MarkOptions) error {
+	if err := opts.checkTimes(action); err != nil {
+		return err
+	}
+
 	var absFileName, err = filepath.Abs(fileName)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to find the absolute path for '%v'!\n", fileName)
-		return err
+		return fmt.Errorf("unable to find the absolute path for '%v' - %w", fileName, err)
 	}
 
-	fmt.Printf("Marking: '%v'!\n", absFileName)
 	fileBase := filepath.Base(absFileName)
 	sdFileName, err := GetSdFile(absFileName)
-
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to get sd file name for '%v'!",
-			absFileName)
 		return err
 	}
 
-	fmt.Printf("SD File: '%v'!\n", sdFileName)
+	if action == Delete {
+		if reason := protection(absFileName, opts.Protected, compileProtected(opts.Protected)); len(reason) > 0 {
+			return fmt.Errorf("%w - not marking '%s' for deletion as %s", ErrProtectedPath, absFileName, reason)
+		}
+	}
+
+	var identity *TargetIdentity
+	if action == Delete {
+		identity, err = GetTargetIdentity(absFileName, opts.Hash)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to describe '%v' - %w", absFileName, err)
+		}
+	}
+
+	logger := loggerOrDefault(opts.Logger)
+	logger.Info("Marking", TargetKey, absFileName, ActionKey, action, SdFileKey, sdFileName)
+	return writeMark(sdFileName, SdRecord{
+		Name:      fileBase,
+		Action:    action,
+		Identity:  identity,
+		ExpiresAt: opts.ExpiresAt,
+		NotBefore: opts.NotBefore,
+	}, logger)
+}
+
+// writeMark writes record to sdFileName, stamping it with the time, this
+// replica and the next logical clock.
+func writeMark(sdFileName string, record SdRecord, logger *slog.Logger) error {
 	sdFolder := filepath.Dir(sdFileName)
 
 	if _, err := os.Stat(sdFolder); os.IsNotExist(err) {
-		fmt.Printf("Making directory '%v'\n", sdFolder)
+		logger.Debug("Making SD folder", "dir", sdFolder)
 		os.Mkdir(sdFolder, 0755)
 	}
 
-	sdFile, err := os.Create(sdFileName)
-	defer sdFile.Close()
+	// Carry the clock on from any existing mark so that this one wins
+	// wherever the two meet.
+	record.Clock = 1
+	if previous, err := GetActionForFile(sdFileName, filepath.Dir(sdFolder)); err == nil {
+		record.Clock = previous.Clock + 1
+	}
+	record.MarkedAt = time.Now()
+	record.Replica = ReplicaID
 
+	sdFile, err := os.Create(sdFileName)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Couldn't create file '%v'!\n",
-			sdFileName)
-		return err
+		return fmt.Errorf("couldn't create '%v' - %w", sdFileName, err)
 	}
+	defer sdFile.Close()
 
-	fmt.Fprintf(sdFile, "%v\n%s\n", fileBase, getStringForAction(action))
-
-	return nil
+	return writeSdFile(sdFile, record)
 }
 
 func ReadSweepFromFile(sweepFromFileName string) ([]string, error) {
@@ -166,207 +340,93 @@ func ReadSweepFromFile(sweepFromFileName string) ([]string, error) {
 	return directoriesToSweep, nil
 }
 
-func SweepFrom(sweepFromFileName string, expiryMonths int, outWriter io.Writer, errWriter io.Writer, verbose bool) error {
+func SweepFrom(sweepFromFileName string, opts SweepOptions) error {
 	var directoriesToSweepFrom, err = ReadSweepFromFile(sweepFromFileName)
 	if err != nil {
-		_, err := fmt.Fprintf(errWriter, "Unable to read file to sweep from '%v' - '%v'\n", sweepFromFileName, err)
-		if err != nil {
-			return err
-		}
-	}
-
-	for _, directoryToSweepFrom := range directoriesToSweepFrom {
-		err := SweepDirectory(directoryToSweepFrom, expiryMonths, outWriter, errWriter, verbose)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-func SweepDirectory(directoryToSweep string, expiryMonths int, outWriter io.Writer, errWriter io.Writer, verbose bool) error {
-	stat, err := os.Stat(directoryToSweep)
-	if err != nil {
+		loggerOrDefault(opts.Logger).Error("Unable to read file to sweep from",
+			"file", sweepFromFileName, ErrorKey, err)
 		return err
 	}
 
-	if !stat.IsDir() {
-		return fmt.Errorf("%s is not a directory", directoryToSweep)
-	}
-
-	type fileToDelete struct {
-		Path, SDFile string
-	}
-
-	absDirectoryToSweep, err := filepath.Abs(directoryToSweep)
-	if err != nil {
-		fmt.Fprintf(errWriter, "Unable to find the absolute path for '%v' - '%v'!\n",
-			directoryToSweep, err)
-		return err
-	}
+	return SweepDirectories(directoriesToSweepFrom, opts)
+}
 
-	sdExpiryCutoff := time.Now().AddDate(0, -1*expiryMonths, 0)
+// SweepDirectory deletes everything marked for deletion under
+// directoryToSweep, streaming each SD folder from discovery to deletion.
+// If some paths couldn't be deleted, it carries on and returns a
+// *PartialFailureError listing them. If the sweep would break opts.Limits,
+// nothing is deleted and a *LimitExceededError is returned.
+// Use PlanSweep and ExecutePlan to see the whole plan before acting on it.
+func SweepDirectory(directoryToSweep string, opts SweepOptions) error {
+	return SweepDirectoryContext(context.Background(), directoryToSweep, opts)
+}
 
-	re, _ := regexp.Compile(`[0-9a-fA-F]+.txt`)
-	if verbose {
-		fmt.Fprintf(outWriter, "Sweeping: '%v'\n", absDirectoryToSweep)
+// SweepDirectoryContext is SweepDirectory, stopping early when ctx is done.
+// The sweep finishes the deletion it is making and returns ctx's error.
+func SweepDirectoryContext(ctx context.Context, directoryToSweep string, opts SweepOptions) error {
+	report := newRootReport(directoryToSweep, opts.DryRun)
+	if absDirectoryToSweep, err := filepath.Abs(directoryToSweep); err == nil {
+		report.Root = absDirectoryToSweep
 	}
-	filesToDelete := make([]fileToDelete, 0)
-	walker := func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			fmt.Fprintf(errWriter, "%v\n", err)
-			return err
-		}
+	report.reporter = opts.Reporter
 
-		if info.IsDir() && info.Name() == SdFolderName {
-			sdFolder := path
-			if verbose {
-				fmt.Fprintf(outWriter, "Search SD folder '%v'\n", sdFolder)
-			}
-			containingFolder := filepath.Dir(sdFolder)
-			if verbose {
-				fmt.Fprintf(outWriter, "Containing folder '%v'\n", containingFolder)
-			}
-
-			sdFiles, err := filepath.Glob(filepath.Join(sdFolder, "*.txt"))
-			if err != nil {
-				fmt.Fprintf(errWriter, "%v\n", err)
-				return err
-			}
-
-			// Remove emptied sd folders
-			if len(sdFiles) == 0 {
-				fmt.Fprintf(outWriter, "Adding empty SD folder '%s' to the delete list\n", sdFolder)
-				filesToDelete = append(filesToDelete, fileToDelete{Path: sdFolder, SDFile: ""})
-			}
-
-			for _, sdFile := range sdFiles {
-				sdStat, err := os.Stat(sdFile)
-				if err != nil {
-					fmt.Fprintf(errWriter, "%v\n", err)
-					return err
-				}
-
-				if !re.Match([]byte(sdStat.Name())) {
-					fmt.Fprintf(outWriter, "'%v' is not a legal name for SD file - deleting.\n",
-						sdFile)
-					filesToDelete = append(filesToDelete, fileToDelete{sdFile, ""})
-					continue
-				}
-
-				if sdStat.ModTime().Before(sdExpiryCutoff) {
-					fmt.Fprintf(outWriter, "Adding old SD file '%v' from %s to the delete list\n",
-						sdFile,
-						sdStat.ModTime().Format("2006-01-02 15:04:05"))
-					filesToDelete = append(filesToDelete, fileToDelete{sdFile, ""})
-					continue
-				}
-
-				if verbose {
-					fmt.Fprintf(outWriter, "SD File '%v'\n", sdFile)
-				}
-				actionForFile, err := GetActionForFile(sdFile, containingFolder, errWriter)
-				if err != nil {
-					fmt.Fprintf(errWriter, "%v\n", err)
-					return err
-				}
-
-				if actionForFile.Action == Delete {
-					if _, err := os.Stat(actionForFile.File); os.IsNotExist(err) {
-						if verbose {
-							fmt.Fprintf(outWriter, "'%v' already deleted.\n", actionForFile.File)
-						}
-						continue
-					}
-					fmt.Fprintf(outWriter, "Adding '%v' to the delete list\n", actionForFile.File)
-					filesToDelete = append(filesToDelete, fileToDelete{actionForFile.File, actionForFile.SdFile})
-				} else if actionForFile.Action == Keep {
-					if verbose {
-						fmt.Fprintf(outWriter, "Keeping '%v'\n", actionForFile.File)
-					}
-				} else {
-					fmt.Fprintf(errWriter, "Unrecognised action '%v' from '%v'!\n",
-						actionForFile.Action, sdFile)
-					fmt.Fprintf(outWriter, "Adding unreadable SD file '%v' from %s to the delete list\n",
-						sdFile,
-						sdStat.ModTime().Format("2006-01-02 15:04:05"))
-					filesToDelete = append(filesToDelete, fileToDelete{sdFile, ""})
-				}
-			}
-		}
+	opts.Logger = loggerOrDefault(opts.Logger).With(RootKey, report.Root)
+	opts.Logger.Debug("Sweeping")
 
-		return nil
+	sweepRoot := sweepStream
+	if opts.Limits.enabled() || opts.Review != nil {
+		sweepRoot = sweepPlanned
 	}
 
-	err = filepath.Walk(absDirectoryToSweep, walker)
-	if err != nil {
-		_, err := fmt.Fprintf(errWriter, "%v\n", err)
-		if err != nil {
-			return err
+	// Dry runs don't take the lock as they change nothing.
+	var err error
+	if opts.DryRun {
+		err = sweepRoot(ctx, directoryToSweep, opts, &report)
+	} else {
+		var release func()
+		release, err = acquireSweepLock(ctx, directoryToSweep, opts)
+		if err == nil {
+			err = sweepRoot(ctx, directoryToSweep, opts, &report)
+			release()
 		}
-		return err
 	}
-
-	var pe *fs.PathError
-	for _, fileToDelete := range filesToDelete {
-		var deleteMessage = fmt.Sprintf("Deleting '%v'", fileToDelete.Path)
-
-		if len(fileToDelete.SDFile) > 0 {
-			deleteMessage += fmt.Sprintf(" as instructed by '%v'", fileToDelete.SDFile)
-		}
-		fmt.Fprintf(outWriter, "%v\n", deleteMessage)
-
-		err = os.RemoveAll(fileToDelete.Path)
-		if err != nil {
-			fmt.Fprintf(errWriter, "%v\n", err)
-			if errors.As(err, &pe) {
-				fmt.Fprintf(errWriter, "Failed to remove %v from %v\n", pe.Path, fileToDelete.SDFile)
-
-				if err != nil {
-					fmt.Fprintf(errWriter, "%v\n", err)
-				}
-			}
-		}
+	// The paths that failed are already in the report and were logged as
+	// they failed.
+	var partial *PartialFailureError
+	var lockHeld *LockHeldError
+	var limit *LimitExceededError
+	switch {
+	case errors.As(err, &partial):
+	case errors.As(err, &limit):
+		opts.Logger.Error("Deleting nothing as the sweep would exceed a deletion limit",
+			"limit", limit.Limit, ErrorKey, err)
+		report.addError(directoryToSweep, err)
+	case err != nil && errors.Is(err, ctx.Err()):
+		opts.Logger.Warn("Sweep stopped before it finished", ErrorKey, err)
+		report.addError(directoryToSweep, err)
+	case errors.As(err, &lockHeld):
+		opts.Logger.Warn("Skipping sweep as a lock is held", "lock_file", lockHeld.LockFile,
+			"holder", lockHeld.Holder)
+		report.addError(directoryToSweep, err)
+	case err != nil:
+		opts.Logger.Error("Sweep failed", ErrorKey, err)
+		report.addError(directoryToSweep, err)
 	}
+	sendReport(opts, report)
 
-	return nil
+	return err
 }
 
-func GetWriters(logsDir string) (io.Writer, io.Writer, error) {
-	if len(logsDir) > 0 {
-		rootLogFolder, err := filepath.Abs(logsDir)
-		if err != nil {
-			return nil, nil, err
-		}
-
-		if _, err := os.Stat(rootLogFolder); os.IsNotExist(err) {
-			fmt.Printf("Making root logs directory '%v'\n", rootLogFolder)
-			os.Mkdir(rootLogFolder, 0755)
-		}
-
-		sdLogFolder := filepath.Join(rootLogFolder, "staydeleted")
-		if _, err := os.Stat(sdLogFolder); os.IsNotExist(err) {
-			fmt.Printf("Making staydeleted logs directory '%v'\n", sdLogFolder)
-			os.Mkdir(sdLogFolder, 0755)
-		}
-
-		timeStr := time.Now().Format("2006-01-02_15.04.05")
-		outLogFileName := filepath.Join(sdLogFolder, fmt.Sprintf("%s.log", timeStr))
-		errLogFileName := filepath.Join(sdLogFolder, fmt.Sprintf("%s.err", timeStr))
-
-		outLogFile, err := os.Create(outLogFileName)
-		if err != nil {
-			return nil, nil, err
-		}
-
-		errLogFile, err := os.Create(errLogFileName)
-		if err != nil {
-			return nil, nil, err
-		}
+func sendReport(opts SweepOptions, report RootReport) {
+	if opts.Reporter == nil {
+		return
+	}
 
-		return outLogFile, errLogFile, nil
-	} else {
-		return os.Stdout, os.Stderr, nil
+	err := report.reportErr
+	if err == nil {
+		err = opts.Reporter.ReportRoot(report)
+	}
+	if err != nil {
+		opts.Logger.Error("Unable to write the report", ErrorKey, err)
 	}
 }
@@ -1,12 +1,64 @@
 package sdlib
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
+func TestMain(m *testing.M) {
+	// Keep the records of tests that don't look at them out of the output.
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// newTestLogger logs everything to w as text.
+func newTestLogger(w io.Writer) *slog.Logger {
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// findLogRecord returns the line number of the first record in log with
+// the message msg and all of attrs, given as key=value, or -1.
+func findLogRecord(log, msg string, attrs ...string) int {
+	for i, line := range strings.Split(log, "\n") {
+		fields := strings.Fields(line)
+		if !strings.Contains(line, "msg="+strconv.Quote(msg)) && !strings.Contains(line, "msg="+msg+" ") {
+			continue
+		}
+		found := true
+		for _, attr := range attrs {
+			if !containsString(fields, attr) {
+				found = false
+				break
+			}
+		}
+		if found {
+			return i
+		}
+	}
+	return -1
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
 func TestGetSdFolder(t *testing.T) {
 	containingDir := t.TempDir()
 	testFileName := filepath.Join(containingDir, "test.txt")
@@ -45,7 +97,7 @@ func TestSetGetAction(t *testing.T) {
 		t.Error(err)
 	}
 
-	gotAction, err := GetActionForFile(sdfp, dir, os.Stderr)
+	gotAction, err := GetActionForFile(sdfp, dir)
 	if err != nil {
 		t.Error(err)
 	}
@@ -58,3 +110,1739 @@ func TestSetGetAction(t *testing.T) {
 		t.Error(fmt.Sprintf("gotAction.Action: %s!", getStringForAction(gotAction.Action)))
 	}
 }
+
+func TestSweepDirectoryDryRun(t *testing.T) {
+	dir := t.TempDir()
+
+	tfp := filepath.Join(dir, "test.txt")
+	tf, _ := os.Create(tfp)
+	tf.Close()
+
+	err := SetActionForFile(tfp, Delete)
+	if err != nil {
+		t.Error(err)
+	}
+
+	var out strings.Builder
+	opts := SweepOptions{ExpiryMonths: 12, DryRun: true, Logger: newTestLogger(&out)}
+	err = SweepDirectory(dir, opts)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if _, err := os.Stat(tfp); err != nil {
+		t.Error(fmt.Sprintf("'%s' was removed during a dry run: %v", tfp, err))
+	}
+
+	if findLogRecord(out.String(), "Would delete", TargetKey+"="+tfp) < 0 {
+		t.Error(fmt.Sprintf("dry run output does not list '%s':\n%s", tfp, out.String()))
+	}
+
+	opts.DryRun = false
+	err = SweepDirectory(dir, opts)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if _, err := os.Stat(tfp); !os.IsNotExist(err) {
+		t.Error(fmt.Sprintf("'%s' was not removed by the sweep", tfp))
+	}
+}
+
+func TestPlanSweep(t *testing.T) {
+	dir := t.TempDir()
+
+	deletedFp := filepath.Join(dir, "deleted.txt")
+	keptFp := filepath.Join(dir, "kept.txt")
+	goneFp := filepath.Join(dir, "gone.txt")
+	for _, fp := range []string{deletedFp, keptFp} {
+		f, _ := os.Create(fp)
+		f.Close()
+	}
+
+	SetActionForFile(deletedFp, Delete)
+	SetActionForFile(keptFp, Keep)
+	SetActionForFile(goneFp, Delete)
+
+	sdFolder, _ := GetSdFolder(deletedFp)
+	os.WriteFile(filepath.Join(sdFolder, "junk.txt"), []byte("junk\n"), 0644)
+
+	plan, err := PlanSweep(dir, SweepOptions{ExpiryMonths: 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kinds := make(map[string]EntryKind)
+	for _, entry := range plan.Entries {
+		kinds[entry.Path] = entry.Kind
+	}
+
+	expected := map[string]EntryKind{
+		deletedFp:                           TargetDeletion,
+		keptFp:                              Kept,
+		goneFp:                              AlreadyDeleted,
+		filepath.Join(sdFolder, "junk.txt"): MalformedSdFile,
+	}
+	for path, kind := range expected {
+		if kinds[path] != kind {
+			t.Error(fmt.Sprintf("'%s' planned as %v, expecting %v", path, kinds[path], kind))
+		}
+	}
+
+	if _, err := os.Stat(deletedFp); err != nil {
+		t.Error("PlanSweep removed a file")
+	}
+
+	deletions := plan.Filter(func(e PlanEntry) bool { return e.Kind == TargetDeletion })
+	_, err = ExecutePlan(deletions, SweepOptions{})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if _, err := os.Stat(deletedFp); !os.IsNotExist(err) {
+		t.Error(fmt.Sprintf("'%s' was not removed by ExecutePlan", deletedFp))
+	}
+	if _, err := os.Stat(filepath.Join(sdFolder, "junk.txt")); err != nil {
+		t.Error("ExecutePlan removed an entry that was filtered out")
+	}
+}
+
+func TestExecutePlanReportsFailures(t *testing.T) {
+	dir := t.TempDir()
+
+	deletedFp := filepath.Join(dir, "deleted.txt")
+	f, _ := os.Create(deletedFp)
+	f.Close()
+	badFp := filepath.Join(dir, "bad\x00name.txt")
+
+	plan := &SweepPlan{Roots: []string{dir}, Entries: []PlanEntry{
+		{Kind: TargetDeletion, Path: badFp},
+		{Kind: TargetDeletion, Path: deletedFp},
+	}}
+	results, err := ExecutePlan(plan, SweepOptions{})
+
+	var partial *PartialFailureError
+	if !errors.As(err, &partial) {
+		t.Fatal(fmt.Sprintf("Expecting a PartialFailureError, got %v", err))
+	}
+	if len(partial.Failures) != 1 || partial.Failures[0].Path != badFp {
+		t.Error(fmt.Sprintf("Unexpected failures %v", partial.Failures))
+	}
+	if len(results) != 2 || len(results[0].Error) == 0 || !results[1].Deleted {
+		t.Error(fmt.Sprintf("Unexpected results %v", results))
+	}
+	if _, err := os.Stat(deletedFp); !os.IsNotExist(err) {
+		t.Error("A failure stopped the remaining deletions")
+	}
+}
+
+func TestExecutePlanLeavesRefilledSdFolder(t *testing.T) {
+	dir := t.TempDir()
+	sdFolder := filepath.Join(dir, SdFolderName)
+	os.Mkdir(sdFolder, 0755)
+
+	plan, err := PlanSweep(dir, SweepOptions{ExpiryMonths: 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Entries) != 1 || plan.Entries[0].Kind != EmptySdFolder {
+		t.Fatal(fmt.Sprintf("expecting '%s' to be planned as empty, got %v", sdFolder, plan.Entries))
+	}
+
+	// An SD file arrives after the plan is made.
+	tfp := filepath.Join(dir, "test.txt")
+	os.WriteFile(tfp, []byte("test\n"), 0644)
+	SetActionForFile(tfp, Delete)
+
+	_, err = ExecutePlan(plan, SweepOptions{})
+	var partial *PartialFailureError
+	if !errors.As(err, &partial) || len(partial.Failures) != 1 || partial.Failures[0].Path != sdFolder {
+		t.Error(fmt.Sprintf("expecting removing '%s' to fail, got %v", sdFolder, err))
+	}
+	sdfp, _ := GetSdFile(tfp)
+	if _, err := os.Stat(sdfp); err != nil {
+		t.Error(fmt.Sprintf("'%s' was deleted with its SD folder", sdfp))
+	}
+}
+
+func TestSweepDirectoryLeavesUnreadableSdFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+
+	dir := t.TempDir()
+	tfp := filepath.Join(dir, "test.txt")
+	os.WriteFile(tfp, []byte("test\n"), 0644)
+	SetActionForFile(tfp, Keep)
+
+	// An SD file that can't be opened, unlike a malformed one, may be fine
+	// once it can be read.
+	sdFolder, _ := GetSdFolder(tfp)
+	unreadable := filepath.Join(sdFolder, "0123abcd.txt")
+	if err := os.Symlink(filepath.Join(dir, "missing"), unreadable); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	err := SweepDirectory(dir, SweepOptions{ExpiryMonths: 12, Logger: newTestLogger(&out)})
+
+	var partial *PartialFailureError
+	if !errors.As(err, &partial) || len(partial.Failures) != 1 || partial.Failures[0].Path != unreadable {
+		t.Error(fmt.Sprintf("Expecting a PartialFailureError for '%s', got %v", unreadable, err))
+	}
+	if _, err := os.Lstat(unreadable); err != nil {
+		t.Error(fmt.Sprintf("'%s' was deleted as it couldn't be read", unreadable))
+	}
+	if findLogRecord(out.String(), "Unable to read SD file", SdFileKey+"="+unreadable) < 0 {
+		t.Error(fmt.Sprintf("'%s' wasn't reported:\n%s", unreadable, out.String()))
+	}
+}
+
+func TestSweepDirectoryIgnoresHostileSdFiles(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	os.Mkdir(root, 0755)
+
+	victim := filepath.Join(dir, "victim.txt")
+	os.WriteFile(victim, []byte("test\n"), 0644)
+
+	hostile := map[string]string{
+		"legacy.txt":   "../victim.txt\ndelete\n",
+		"absolute.txt": "staydeleted 2\nname: " + victim + "\naction: delete\n",
+		"root.txt":     "\ndelete\n",
+		"pattern.txt":  "staydeleted 2\npattern: ../*.txt\naction: delete\n",
+	}
+	sdFiles := make([]string, 0)
+	for name, contents := range hostile {
+		sdfp, _ := GetSdFile(filepath.Join(root, name))
+		os.MkdirAll(filepath.Dir(sdfp), 0755)
+		os.WriteFile(sdfp, []byte(contents), 0644)
+		sdFiles = append(sdFiles, sdfp)
+	}
+
+	plan, err := PlanSweep(root, SweepOptions{ExpiryMonths: 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range plan.Entries {
+		if entry.Kind != HostileSdFile {
+			t.Error(fmt.Sprintf("'%s' planned as %v, expecting %v", entry.Path, entry.Kind, HostileSdFile))
+		}
+	}
+
+	if err := SweepDirectory(root, SweepOptions{ExpiryMonths: 12}); err != nil {
+		t.Fatal(err)
+	}
+	for _, fp := range append(sdFiles, victim, root) {
+		if _, err := os.Stat(fp); err != nil {
+			t.Error(fmt.Sprintf("'%s' was removed by a hostile SD file", fp))
+		}
+	}
+}
+
+func TestExecutePlanRefusesSymlinkedTarget(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	sub := filepath.Join(root, "sub")
+	os.MkdirAll(sub, 0755)
+	tfp := filepath.Join(sub, "test.txt")
+	os.WriteFile(tfp, []byte("test\n"), 0644)
+	SetActionForFile(tfp, Delete)
+
+	plan, err := PlanSweep(root, SweepOptions{ExpiryMonths: 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Swap the folder for a symlink to another with the same file in it
+	// between planning and deleting.
+	outside := filepath.Join(dir, "outside")
+	os.Rename(sub, outside)
+	os.Symlink(outside, sub)
+
+	deletions := plan.Filter(func(e PlanEntry) bool { return e.Kind == TargetDeletion })
+	_, err = ExecutePlan(deletions, SweepOptions{})
+	if !errors.Is(err, ErrHostileSdFile) {
+		t.Error(fmt.Sprintf("expecting a hostile SD file error, got %v", err))
+	}
+	if _, err := os.Stat(filepath.Join(outside, "test.txt")); err != nil {
+		t.Error("a file was deleted through a symlink")
+	}
+}
+
+func TestSweepDirectoryLimits(t *testing.T) {
+	root := t.TempDir()
+	files := make([]string, 0)
+	for i := 0; i < 10; i++ {
+		tfp := filepath.Join(root, fmt.Sprintf("test%d.txt", i))
+		os.WriteFile(tfp, []byte("0123456789"), 0644)
+		files = append(files, tfp)
+	}
+	for _, tfp := range files[:4] {
+		SetActionForFile(tfp, Delete)
+	}
+
+	var cases = []struct {
+		limits DeletionLimits
+		limit  string
+	}{
+		{DeletionLimits{MaxTargets: 3}, "max-targets"},
+		{DeletionLimits{MaxBytes: 39}, "max-bytes"},
+		{DeletionLimits{MaxPercent: 30}, "max-percent"},
+	}
+	for _, c := range cases {
+		var log strings.Builder
+		err := SweepDirectory(root, SweepOptions{ExpiryMonths: 12, Limits: c.limits, Logger: newTestLogger(&log)})
+		var limit *LimitExceededError
+		if !errors.As(err, &limit) || limit.Limit != c.limit {
+			t.Error(fmt.Sprintf("expecting the %s limit to be exceeded, got %v", c.limit, err))
+		}
+		if findLogRecord(log.String(), "Adding to the delete list", TargetKey+"="+files[0]) < 0 {
+			t.Error(fmt.Sprintf("the plan wasn't logged when the %s limit was exceeded", c.limit))
+		}
+		for _, tfp := range files {
+			if _, err := os.Stat(tfp); err != nil {
+				t.Error(fmt.Sprintf("'%s' was deleted despite the %s limit", tfp, c.limit))
+			}
+		}
+	}
+
+	limits := DeletionLimits{MaxTargets: 4, MaxBytes: 40, MaxPercent: 40}
+	if err := SweepDirectory(root, SweepOptions{ExpiryMonths: 12, Limits: limits}); err != nil {
+		t.Fatal(err)
+	}
+	for i, tfp := range files {
+		if _, err := os.Stat(tfp); os.IsNotExist(err) != (i < 4) {
+			t.Error(fmt.Sprintf("'%s' was not swept as marked within the limits", tfp))
+		}
+	}
+}
+
+func TestSweepDirectoryLimitsEmptiedFolder(t *testing.T) {
+	root := t.TempDir()
+	for i := 0; i < 50; i++ {
+		os.WriteFile(filepath.Join(root, fmt.Sprintf("keep%d.txt", i)), []byte("test\n"), 0644)
+	}
+	sub := filepath.Join(root, "sub")
+	os.Mkdir(sub, 0755)
+	files := make([]string, 0)
+	for i := 0; i < 3; i++ {
+		tfp := filepath.Join(sub, fmt.Sprintf("test%d.txt", i))
+		os.WriteFile(tfp, []byte("test\n"), 0644)
+		SetActionForFile(tfp, Delete)
+		files = append(files, tfp)
+	}
+
+	// Three files are a small share of the root, but all of sub.
+	err := SweepDirectory(root, SweepOptions{ExpiryMonths: 12, Limits: DeletionLimits{MaxPercent: 20}})
+	var limit *LimitExceededError
+	if !errors.As(err, &limit) || limit.Limit != "max-percent" || limit.Dir != sub || limit.Planned != 100 {
+		t.Error(fmt.Sprintf("expecting emptying '%s' to exceed the max-percent limit, got %v", sub, err))
+	}
+	for _, tfp := range files {
+		if _, err := os.Stat(tfp); err != nil {
+			t.Error(fmt.Sprintf("'%s' was deleted despite the max-percent limit", tfp))
+		}
+	}
+
+	// Deleting sub whole is one of the root's 51 files and folders.
+	for _, tfp := range files {
+		SetActionForFile(tfp, Keep)
+	}
+	SetActionForFile(sub, Delete)
+	if err := SweepDirectory(root, SweepOptions{ExpiryMonths: 12, Limits: DeletionLimits{MaxPercent: 20}}); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(sub); !os.IsNotExist(err) {
+		t.Error(fmt.Sprintf("'%s' was not swept within the max-percent limit", sub))
+	}
+}
+
+func TestSweepDirectoryPlannedNestedMarks(t *testing.T) {
+	root := t.TempDir()
+	parent := filepath.Join(root, "a", "b")
+	child := filepath.Join(parent, "x")
+	os.MkdirAll(child, 0755)
+	SetActionForFile(parent, Delete)
+	SetActionForFile(child, Delete)
+
+	plan, err := PlanSweep(root, SweepOptions{ExpiryMonths: 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := make([]string, 0)
+	for _, entry := range plan.Deletions() {
+		order = append(order, entry.Path)
+	}
+	if len(order) != 2 || order[0] != child || order[1] != parent {
+		t.Error(fmt.Sprintf("expecting '%s' to be deleted before '%s', got %v", child, parent, order))
+	}
+
+	// Limits make the sweep plan the whole root before deleting anything.
+	opts := SweepOptions{ExpiryMonths: 12, Limits: DeletionLimits{MaxTargets: 100}}
+	if err := SweepDirectory(root, opts); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(parent); !os.IsNotExist(err) {
+		t.Error(fmt.Sprintf("'%s' was not removed by the sweep", parent))
+	}
+
+	// A plan made in the other order finds the child already gone.
+	os.MkdirAll(child, 0755)
+	SetActionForFile(parent, Delete)
+	SetActionForFile(child, Delete)
+	plan, err = PlanSweep(root, SweepOptions{ExpiryMonths: 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reversed := plan.Filter(func(e PlanEntry) bool { return e.Path == parent })
+	reversed.Entries = append(reversed.Entries, plan.Filter(func(e PlanEntry) bool { return e.Path == child }).Entries...)
+	if _, err := ExecutePlan(reversed, SweepOptions{}); err != nil {
+		t.Error(fmt.Sprintf("expecting a target in a deleted folder to be done, got %v", err))
+	}
+}
+
+func TestSweepDirectoryProtected(t *testing.T) {
+	root := t.TempDir()
+	repo := filepath.Join(root, "repo")
+	os.MkdirAll(filepath.Join(repo, ".git"), 0755)
+	tax := filepath.Join(root, "tax")
+	os.MkdirAll(tax, 0755)
+	taxReturn := filepath.Join(tax, "2025.pdf")
+	deletable := filepath.Join(root, "deletable.txt")
+	for _, fp := range []string{taxReturn, deletable} {
+		os.WriteFile(fp, []byte("test\n"), 0644)
+	}
+	for _, fp := range []string{repo, taxReturn, deletable} {
+		SetActionForFile(fp, Delete)
+	}
+
+	protected := []string{".git", filepath.Join(root, "tax", "**")}
+	if err := MarkFile(taxReturn, Delete, MarkOptions{Protected: protected}); !errors.Is(err, ErrProtectedPath) {
+		t.Error(fmt.Sprintf("expecting marking a protected path to fail, got %v", err))
+	}
+
+	var log strings.Builder
+	err := SweepDirectory(root, SweepOptions{ExpiryMonths: 12, Protected: protected, Logger: newTestLogger(&log)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fp := range []string{repo, taxReturn} {
+		if _, err := os.Stat(fp); err != nil {
+			t.Error(fmt.Sprintf("protected '%s' was deleted", fp))
+		}
+		if findLogRecord(log.String(), "Not deleting protected path", TargetKey+"="+fp) < 0 {
+			t.Error(fmt.Sprintf("protected '%s' wasn't reported:\n%s", fp, log.String()))
+		}
+	}
+	if _, err := os.Stat(deletable); !os.IsNotExist(err) {
+		t.Error(fmt.Sprintf("'%s' was not removed by the sweep", deletable))
+	}
+}
+
+func TestMatchProtected(t *testing.T) {
+	var cases = []struct {
+		pattern, path string
+		matched       bool
+	}{
+		{"**/.git", "/home/me/repo/.git", true},
+		{".git", "/home/me/repo/.git", true},
+		{".git", "/home/me/repo/.github", false},
+		{"/home/me/tax/**", "/home/me/tax", true},
+		{"/home/me/tax/**", "/home/me/tax/2025/return.pdf", true},
+		{"/home/me/tax/**", "/home/me/taxes", false},
+		{"/home/*/tax", "/home/me/tax", true},
+		{"/home/*/tax", "/home/me/work/tax", false},
+	}
+	for _, c := range cases {
+		patterns := []string{filepath.FromSlash(c.pattern)}
+		matched := len(protectedBy(filepath.FromSlash(c.path), patterns, compileProtected(patterns))) > 0
+		if matched != c.matched {
+			t.Error(fmt.Sprintf("'%s' matching '%s' is %v, expecting %v", c.pattern, c.path, matched, c.matched))
+		}
+	}
+}
+
+func TestSweepDirectoryReview(t *testing.T) {
+	dir := t.TempDir()
+	approvedFp := filepath.Join(dir, "approved.txt")
+	skippedFp := filepath.Join(dir, "skipped.txt")
+	for _, fp := range []string{approvedFp, skippedFp} {
+		os.WriteFile(fp, []byte("test\n"), 0644)
+		SetActionForFile(fp, Delete)
+	}
+
+	reviewed := make([]string, 0)
+	review := func(entry PlanEntry) bool {
+		reviewed = append(reviewed, entry.Path)
+		return entry.Path == approvedFp
+	}
+	if err := SweepDirectory(dir, SweepOptions{ExpiryMonths: 12, Review: review}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reviewed) != 2 {
+		t.Error(fmt.Sprintf("expecting both targets to be reviewed, got %v", reviewed))
+	}
+	if _, err := os.Stat(approvedFp); !os.IsNotExist(err) {
+		t.Error(fmt.Sprintf("approved '%s' was not removed", approvedFp))
+	}
+	if _, err := os.Stat(skippedFp); err != nil {
+		t.Error(fmt.Sprintf("skipped '%s' was removed", skippedFp))
+	}
+
+	// A skipped target is still marked for the next sweep.
+	if err := SweepDirectory(dir, SweepOptions{ExpiryMonths: 12}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(skippedFp); !os.IsNotExist(err) {
+		t.Error(fmt.Sprintf("skipped '%s' was not removed by the next sweep", skippedFp))
+	}
+}
+
+func TestPlanSweepMarkTimes(t *testing.T) {
+	dir := t.TempDir()
+	notDueFp := filepath.Join(dir, "not-due.txt")
+	dueFp := filepath.Join(dir, "due.txt")
+	expiredFp := filepath.Join(dir, "expired.txt")
+	longLivedFp := filepath.Join(dir, "long-lived.txt")
+	for _, fp := range []string{notDueFp, dueFp, expiredFp, longLivedFp} {
+		os.WriteFile(fp, []byte("test\n"), 0644)
+	}
+
+	now := time.Now()
+	MarkFile(notDueFp, Delete, MarkOptions{NotBefore: now.Add(time.Hour)})
+	MarkFile(dueFp, Delete, MarkOptions{NotBefore: now.Add(-time.Hour)})
+	MarkFile(expiredFp, Delete, MarkOptions{ExpiresAt: now.Add(-time.Hour)})
+	MarkFile(longLivedFp, Delete, MarkOptions{ExpiresAt: now.AddDate(3, 0, 0)})
+	if err := MarkFile(notDueFp, Keep, MarkOptions{NotBefore: now}); err == nil {
+		t.Error("a mark to keep was given a time to delete after")
+	}
+
+	// With no expiry of its own a mark expires straight away, so only
+	// the long lived mark is acted on.
+	plan, err := PlanSweep(dir, SweepOptions{ExpiryMonths: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := make(map[string]EntryKind)
+	for _, entry := range plan.Entries {
+		kinds[entry.Path] = entry.Kind
+	}
+	if kinds[longLivedFp] != TargetDeletion {
+		t.Error(fmt.Sprintf("'%s' planned as %v, expecting %v", longLivedFp, kinds[longLivedFp], TargetDeletion))
+	}
+	if sdFile, _ := GetSdFile(dueFp); kinds[sdFile] != ExpiredSdFile {
+		t.Error(fmt.Sprintf("'%s' planned as %v, expecting %v", sdFile, kinds[sdFile], ExpiredSdFile))
+	}
+
+	plan, err = PlanSweep(dir, SweepOptions{ExpiryMonths: 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds = make(map[string]EntryKind)
+	for _, entry := range plan.Entries {
+		kinds[entry.Path] = entry.Kind
+	}
+	expiredSdFile, _ := GetSdFile(expiredFp)
+	expected := map[string]EntryKind{
+		notDueFp:      NotYetDue,
+		dueFp:         TargetDeletion,
+		expiredSdFile: ExpiredSdFile,
+		longLivedFp:   TargetDeletion,
+	}
+	for path, kind := range expected {
+		if kinds[path] != kind {
+			t.Error(fmt.Sprintf("'%s' planned as %v, expecting %v", path, kinds[path], kind))
+		}
+	}
+}
+
+func TestSweepDirectoryJSONReport(t *testing.T) {
+	dir := t.TempDir()
+
+	tfp := filepath.Join(dir, "test.txt")
+	os.WriteFile(tfp, []byte("0123456789"), 0644)
+	SetActionForFile(tfp, Delete)
+
+	var out strings.Builder
+	reporter, err := NewReporter("json", &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := SweepOptions{ExpiryMonths: 12, Reporter: reporter}
+	err = SweepDirectory(dir, opts)
+	if err != nil {
+		t.Error(err)
+	}
+	reporter.Close()
+
+	var report SweepReport
+	if err := json.Unmarshal([]byte(out.String()), &report); err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Version != ReportVersion || len(report.Roots) != 1 {
+		t.Fatal(fmt.Sprintf("unexpected report:\n%s", out.String()))
+	}
+
+	entries := report.Roots[0].Entries
+	if len(entries) != 1 || entries[0].Kind != TargetDeletion || entries[0].Path != tfp || !entries[0].Deleted {
+		t.Error(fmt.Sprintf("unexpected entries: %+v", entries))
+	}
+
+	if report.Totals.Deleted != 1 || report.Totals.BytesFreed != 10 {
+		t.Error(fmt.Sprintf("unexpected totals: %+v", report.Totals))
+	}
+
+	// The entries are spooled while sweeping, but the report should read
+	// as if it had been encoded in one go.
+	expected, _ := json.MarshalIndent(report, "", "  ")
+	if out.String() != string(expected)+"\n" {
+		t.Error(fmt.Sprintf("report layout differs, got:\n%s\nexpecting:\n%s", out.String(), expected))
+	}
+}
+
+func TestSweepDirectoryToTrash(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the freedesktop.org trash is not supported on Windows")
+	}
+
+	dataHome := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", dataHome)
+
+	dir := t.TempDir()
+	tfp := filepath.Join(dir, "test file.txt")
+	os.WriteFile(tfp, []byte("test\n"), 0644)
+	SetActionForFile(tfp, Delete)
+
+	opts := SweepOptions{ExpiryMonths: 12, DeleteMode: TrashMode}
+	err := SweepDirectory(dir, opts)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if _, err := os.Stat(tfp); !os.IsNotExist(err) {
+		t.Error(fmt.Sprintf("'%s' was not moved to the trash", tfp))
+	}
+
+	trashDir := filepath.Join(dataHome, "Trash")
+	if _, err := os.Stat(filepath.Join(trashDir, "files", "test file.txt")); err != nil {
+		t.Error(err)
+	}
+
+	info, err := os.ReadFile(filepath.Join(trashDir, "info", "test file.txt.trashinfo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	escapedPath := strings.ReplaceAll(filepath.ToSlash(tfp), " ", "%20")
+	if !strings.Contains(string(info), "Path="+escapedPath+"\n") {
+		t.Error(fmt.Sprintf("unexpected trashinfo:\n%s", info))
+	}
+}
+
+func TestGetActionForLegacySdFile(t *testing.T) {
+	dir := t.TempDir()
+
+	tfp := filepath.Join(dir, "test.txt")
+	sdfp, _ := GetSdFile(tfp)
+	os.Mkdir(filepath.Dir(sdfp), 0755)
+	os.WriteFile(sdfp, []byte("test.txt\ndelete\n"), 0644)
+
+	gotAction, err := GetActionForFile(sdfp, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gotAction.File != tfp || gotAction.Action != Delete {
+		t.Error(fmt.Sprintf("unexpected action: %+v", gotAction))
+	}
+
+	if gotAction.Version != LegacySdFileVersion || !gotAction.MarkedAt.IsZero() {
+		t.Error(fmt.Sprintf("legacy SD file read as version %d marked at %v",
+			gotAction.Version, gotAction.MarkedAt))
+	}
+}
+
+func TestSweepDirectoryUsesEmbeddedMarkTime(t *testing.T) {
+	dir := t.TempDir()
+
+	tfp := filepath.Join(dir, "test.txt")
+	tf, _ := os.Create(tfp)
+	tf.Close()
+
+	sdfp, _ := GetSdFile(tfp)
+	os.Mkdir(filepath.Dir(sdfp), 0755)
+	markedAt := time.Now().AddDate(-2, 0, 0).UTC().Truncate(time.Second)
+	sdf, _ := os.Create(sdfp)
+	writeSdFile(sdf, SdRecord{Name: "test.txt", Action: Delete, MarkedAt: markedAt})
+	sdf.Close()
+
+	plan, err := PlanSweep(dir, SweepOptions{ExpiryMonths: 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan.Entries) != 1 || plan.Entries[0].Kind != ExpiredSdFile {
+		t.Fatal(fmt.Sprintf("SD file with a fresh mtime but an old mark was planned as %+v", plan.Entries))
+	}
+
+	if !plan.Entries[0].MarkedAt.Equal(markedAt) {
+		t.Error(fmt.Sprintf("MarkedAt is %v, expecting %v", plan.Entries[0].MarkedAt, markedAt))
+	}
+}
+
+func writeTestSdFile(t testing.TB, sdfp string, record SdRecord) {
+	t.Helper()
+
+	os.MkdirAll(filepath.Dir(sdfp), 0755)
+	sdf, err := os.Create(sdfp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sdf.Close()
+
+	if err := writeSdFile(sdf, record); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetActionAdvancesClock(t *testing.T) {
+	dir := t.TempDir()
+	tfp := filepath.Join(dir, "test.txt")
+
+	SetActionForFile(tfp, Delete)
+	SetActionForFile(tfp, Keep)
+
+	sdfp, _ := GetSdFile(tfp)
+	gotAction, err := GetActionForFile(sdfp, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gotAction.Clock != 2 || gotAction.Replica != ReplicaID {
+		t.Error(fmt.Sprintf("clock %d from '%s' after marking twice", gotAction.Clock, gotAction.Replica))
+	}
+}
+
+func TestPlanSweepAppliesNewestConflictingMark(t *testing.T) {
+	dir := t.TempDir()
+
+	tfp := filepath.Join(dir, "test.txt")
+	tf, _ := os.Create(tfp)
+	tf.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	sdfp, _ := GetSdFile(tfp)
+	conflictfp := strings.TrimSuffix(sdfp, ".txt") + ".sync-conflict-20240101-000000-ABCDEF.txt"
+	writeTestSdFile(t, sdfp, SdRecord{Name: "test.txt", Action: Delete, MarkedAt: now, Replica: "a", Clock: 1})
+	writeTestSdFile(t, conflictfp, SdRecord{Name: "test.txt", Action: Keep, MarkedAt: now.Add(-time.Hour), Replica: "b", Clock: 2})
+
+	plan, err := PlanSweep(dir, SweepOptions{ExpiryMonths: 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kinds := make(map[string]EntryKind)
+	for _, entry := range plan.Entries {
+		kinds[entry.SdFile] = entry.Kind
+	}
+
+	if kinds[conflictfp] != Kept || kinds[sdfp] != SupersededSdFile {
+		t.Error(fmt.Sprintf("unexpected plan: %+v", plan.Entries))
+	}
+}
+
+func TestResolveConflicts(t *testing.T) {
+	replicaA := t.TempDir()
+	replicaB := t.TempDir()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	sdfpA, _ := GetSdFile(filepath.Join(replicaA, "sub", "test.txt"))
+	sdfpB, _ := GetSdFile(filepath.Join(replicaB, "sub", "test.txt"))
+	writeTestSdFile(t, sdfpA, SdRecord{Name: "test.txt", Action: Keep, MarkedAt: now, Replica: "a", Clock: 3})
+	writeTestSdFile(t, sdfpB, SdRecord{Name: "test.txt", Action: Delete, MarkedAt: now.Add(time.Hour), Replica: "b", Clock: 2})
+
+	err := ResolveConflicts([]string{replicaA, replicaB}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotAction, err := GetActionForFile(sdfpB, filepath.Join(replicaB, "sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gotAction.Action != Keep || gotAction.Clock != 3 || gotAction.Replica != "a" {
+		t.Error(fmt.Sprintf("stale SD file not rewritten: %+v", gotAction.SdRecord))
+	}
+}
+
+func TestSweepDirectorySkipsRecreatedTarget(t *testing.T) {
+	dir := t.TempDir()
+
+	tfp := filepath.Join(dir, "report.pdf")
+	os.WriteFile(tfp, []byte("old report\n"), 0644)
+	SetActionForFile(tfp, Delete)
+
+	os.Remove(tfp)
+	os.WriteFile(tfp, []byte("brand new report\n"), 0644)
+
+	plan, err := PlanSweep(dir, SweepOptions{ExpiryMonths: 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan.Entries) != 1 || plan.Entries[0].Kind != ChangedTarget {
+		t.Fatal(fmt.Sprintf("recreated target planned as %+v", plan.Entries))
+	}
+
+	err = SweepDirectory(dir, SweepOptions{ExpiryMonths: 12})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if _, err := os.Stat(tfp); err != nil {
+		t.Error(fmt.Sprintf("recreated '%s' was deleted", tfp))
+	}
+}
+
+func TestSweepDirectoryMatchesHashedTarget(t *testing.T) {
+	dir := t.TempDir()
+
+	tfp := filepath.Join(dir, "test.txt")
+	os.WriteFile(tfp, []byte("test\n"), 0644)
+	err := MarkFile(tfp, Delete, MarkOptions{Hash: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A copy restored without its modification time is still the marked file.
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(tfp, later, later)
+
+	err = SweepDirectory(dir, SweepOptions{ExpiryMonths: 12})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if _, err := os.Stat(tfp); !os.IsNotExist(err) {
+		t.Error(fmt.Sprintf("'%s' was not removed by the sweep", tfp))
+	}
+}
+
+func TestSweepDirectoryAllowsCoarseModTimes(t *testing.T) {
+	dir := t.TempDir()
+
+	tfp := filepath.Join(dir, "test.txt")
+	os.WriteFile(tfp, []byte("test\n"), 0644)
+	marked := time.Date(2026, 1, 2, 3, 4, 5, 678901234, time.UTC)
+	os.Chtimes(tfp, marked, marked)
+	if err := SetActionForFile(tfp, Delete); err != nil {
+		t.Fatal(err)
+	}
+
+	// A replica on FAT keeps the time rounded to two seconds.
+	copied := time.Date(2026, 1, 2, 3, 4, 6, 0, time.UTC)
+	os.Chtimes(tfp, copied, copied)
+
+	if err := SweepDirectory(dir, SweepOptions{ExpiryMonths: 12}); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(tfp); !os.IsNotExist(err) {
+		t.Error(fmt.Sprintf("'%s' with a coarser modification time was not removed", tfp))
+	}
+}
+
+func TestSweepDirectoryPatternMark(t *testing.T) {
+	dir := t.TempDir()
+
+	subDir := filepath.Join(dir, "sub")
+	os.Mkdir(subDir, 0755)
+	topTmp := filepath.Join(dir, "a.tmp")
+	subTmp := filepath.Join(subDir, "b.tmp")
+	keptTmp := filepath.Join(dir, "keep.tmp")
+	other := filepath.Join(dir, "c.txt")
+	for _, fp := range []string{topTmp, subTmp, keptTmp, other} {
+		os.WriteFile(fp, []byte("test\n"), 0644)
+	}
+
+	err := MarkPattern(dir, "*.tmp", true, Delete, MarkOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetActionForFile(keptTmp, Keep)
+
+	err = SweepDirectory(dir, SweepOptions{ExpiryMonths: 12})
+	if err != nil {
+		t.Error(err)
+	}
+
+	for _, fp := range []string{topTmp, subTmp} {
+		if _, err := os.Stat(fp); !os.IsNotExist(err) {
+			t.Error(fmt.Sprintf("'%s' matching the pattern was not removed", fp))
+		}
+	}
+	for _, fp := range []string{keptTmp, other} {
+		if _, err := os.Stat(fp); err != nil {
+			t.Error(fmt.Sprintf("'%s' was removed", fp))
+		}
+	}
+}
+
+func TestListMarks(t *testing.T) {
+	dir := t.TempDir()
+
+	existingFp := filepath.Join(dir, "existing.txt")
+	os.WriteFile(existingFp, []byte("test\n"), 0644)
+	SetActionForFile(existingFp, Keep)
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	SetActionForFile(filepath.Join(dir, "sub", "missing.txt"), Delete)
+
+	marks, err := ListMarks(dir, 12, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(marks) != 2 {
+		t.Fatal(fmt.Sprintf("expecting 2 marks, got %+v", marks))
+	}
+
+	for _, mark := range marks {
+		exists := mark.Target == existingFp
+		if mark.TargetExists != exists {
+			t.Error(fmt.Sprintf("'%s' exists is %t", mark.Target, mark.TargetExists))
+		}
+		if exists != (mark.Action == Keep) {
+			t.Error(fmt.Sprintf("'%s' has action %v", mark.Target, mark.Action))
+		}
+		if !mark.ExpiresAt.Equal(mark.MarkedAt.AddDate(1, 0, 0)) {
+			t.Error(fmt.Sprintf("'%s' expires at %v", mark.Target, mark.ExpiresAt))
+		}
+	}
+}
+
+func TestExplainPath(t *testing.T) {
+	dir := t.TempDir()
+
+	tfp := filepath.Join(dir, "test.txt")
+	os.WriteFile(tfp, []byte("test\n"), 0644)
+	SetActionForFile(tfp, Delete)
+
+	explanation, err := ExplainPath(tfp, "", SweepOptions{ExpiryMonths: 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !explanation.SdFileExists || explanation.Mark == nil || explanation.Mark.Action != Delete {
+		t.Fatal(fmt.Sprintf("unexpected explanation: %+v", explanation))
+	}
+
+	if len(explanation.Outcomes) != 1 || explanation.Outcomes[0].Kind != TargetDeletion {
+		t.Error(fmt.Sprintf("unexpected outcomes: %+v", explanation.Outcomes))
+	}
+
+	unmarked, err := ExplainPath(filepath.Join(dir, "other.txt"), "", SweepOptions{ExpiryMonths: 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if unmarked.SdFileExists || len(unmarked.Outcomes) != 0 {
+		t.Error(fmt.Sprintf("unexpected explanation of an unmarked file: %+v", unmarked))
+	}
+}
+
+func TestExplainPathWithSweepOptions(t *testing.T) {
+	dir := t.TempDir()
+	cache := filepath.Join(dir, "cache")
+	os.Mkdir(cache, 0755)
+
+	tfp := filepath.Join(dir, "test.tmp")
+	cachedFp := filepath.Join(cache, "test.txt")
+	for _, fp := range []string{tfp, cachedFp} {
+		os.WriteFile(fp, []byte("test\n"), 0644)
+		SetActionForFile(fp, Delete)
+	}
+
+	opts := SweepOptions{ExpiryMonths: 12, Exclude: []string{"*.tmp", "cache"}}
+	explanation, err := ExplainPath(tfp, dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(explanation.Outcomes) != 1 || explanation.Outcomes[0].Kind != Excluded {
+		t.Error(fmt.Sprintf("unexpected outcomes for an excluded target: %+v", explanation.Outcomes))
+	}
+
+	// A sweep of dir never reaches the SD folder in the excluded folder.
+	explanation, err = ExplainPath(cachedFp, dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(explanation.Outcomes) != 0 {
+		t.Error(fmt.Sprintf("unexpected outcomes in an excluded folder: %+v", explanation.Outcomes))
+	}
+
+	explanation, err = ExplainPath(cachedFp, cache, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(explanation.Outcomes) != 1 || explanation.Outcomes[0].Kind != TargetDeletion {
+		t.Error(fmt.Sprintf("unexpected outcomes sweeping the excluded folder: %+v", explanation.Outcomes))
+	}
+}
+
+func TestIsWithinVolumeRoot(t *testing.T) {
+	dir := t.TempDir()
+	volumeRoot := filepath.VolumeName(dir) + string(filepath.Separator)
+	sub := filepath.Join(dir, "sub")
+
+	cases := []struct {
+		path, dir string
+		within    bool
+	}{
+		{dir, volumeRoot, true},
+		{volumeRoot, volumeRoot, false},
+		{sub, dir, true},
+		{dir, dir, false},
+		{dir, sub, false},
+		{dir + "other", dir, false},
+		{filepath.Join(dir, "..other"), dir, true},
+	}
+	for _, c := range cases {
+		if isWithin(c.path, c.dir) != c.within {
+			t.Error(fmt.Sprintf("isWithin('%s', '%s') is %v", c.path, c.dir, !c.within))
+		}
+	}
+
+	// A mark in an SD folder at the top of a drive isn't hostile.
+	if real, _ := filepath.EvalSymlinks(dir); real == dir {
+		if escape := escapesFolder(dir, volumeRoot); len(escape) > 0 {
+			t.Error(fmt.Sprintf("'%s' escapes '%s': %s", dir, volumeRoot, escape))
+		}
+	}
+
+	deduped := dedupeRoots([]string{volumeRoot, dir}, newTestLogger(io.Discard))
+	if len(deduped) != 1 || deduped[0] != volumeRoot {
+		t.Error(fmt.Sprintf("'%s' wasn't covered by '%s': %v", dir, volumeRoot, deduped))
+	}
+}
+
+func TestSweepDirectoriesInParallel(t *testing.T) {
+	roots := make([]string, 0)
+	targets := make([]string, 0)
+	for i := 0; i < 4; i++ {
+		root := t.TempDir()
+		tfp := filepath.Join(root, "test.txt")
+		os.WriteFile(tfp, []byte("test\n"), 0644)
+		SetActionForFile(tfp, Delete)
+
+		roots = append(roots, root)
+		targets = append(targets, tfp)
+	}
+
+	subDir := filepath.Join(roots[0], "sub")
+	os.Mkdir(subDir, 0755)
+	missing := filepath.Join(t.TempDir(), "missing")
+	roots = append([]string{missing, subDir}, roots...)
+
+	var out strings.Builder
+	opts := SweepOptions{ExpiryMonths: 12, Jobs: 3, Logger: newTestLogger(&out)}
+	err := SweepDirectories(roots, opts)
+	if err == nil || !strings.Contains(err.Error(), missing) {
+		t.Error(fmt.Sprintf("expecting an error for '%s', got %v", missing, err))
+	}
+
+	if findLogRecord(out.String(), "Skipping root inside another root", RootKey+"="+subDir, "covering_root="+roots[2]) < 0 {
+		t.Error(fmt.Sprintf("nested root was not skipped:\n%s", out.String()))
+	}
+
+	// The records of each root are written together.
+	finished := make(map[string]bool)
+	var current string
+	for _, line := range strings.Split(out.String(), "\n") {
+		var root string
+		for _, field := range strings.Fields(line) {
+			if value, found := strings.CutPrefix(field, RootKey+"="); found {
+				root = value
+			}
+		}
+		if len(root) == 0 || root == current {
+			continue
+		}
+		if finished[root] {
+			t.Error(fmt.Sprintf("records for '%s' are interleaved with other roots:\n%s", root, out.String()))
+		}
+		finished[current] = true
+		current = root
+	}
+
+	for _, tfp := range targets {
+		if _, err := os.Stat(tfp); !os.IsNotExist(err) {
+			t.Error(fmt.Sprintf("'%s' was not removed by the sweep", tfp))
+		}
+	}
+}
+
+func TestBufferedLoggerSpoolsRecords(t *testing.T) {
+	noTime := func(groups []string, a slog.Attr) slog.Attr {
+		if a.Key == slog.TimeKey && len(groups) == 0 {
+			return slog.Attr{}
+		}
+		return a
+	}
+	logRecords := func(logger *slog.Logger) {
+		logger = logger.With(RootKey, "/data").WithGroup("sweep")
+		logger.Info("Removed", TargetKey, "a b.txt", "count", 3, "size", uint64(7), "ratio", 0.5,
+			"dry_run", true, "took", time.Second, "at", time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+			ErrorKey, errors.New("gone"), slog.Group("limit", "max", 10))
+		logger.Warn("Skipped")
+	}
+
+	var direct, buffered strings.Builder
+	handlerOpts := &slog.HandlerOptions{Level: slog.LevelDebug, ReplaceAttr: noTime}
+	logRecords(slog.New(slog.NewTextHandler(&direct, handlerOpts)))
+
+	logger, flush := newBufferedLogger(slog.New(slog.NewTextHandler(&buffered, handlerOpts)))
+	logRecords(logger)
+	if buffered.Len() > 0 {
+		t.Error(fmt.Sprintf("records were written before being flushed:\n%s", buffered.String()))
+	}
+	flush()
+
+	if buffered.String() != direct.String() {
+		t.Error(fmt.Sprintf("expecting the records\n%s\ngot\n%s", direct.String(), buffered.String()))
+	}
+}
+
+// makeSyntheticTree makes a tree of dirs folders, each holding files files,
+// with every tenth folder holding a file marked for deletion.
+func makeSyntheticTree(tb testing.TB, dirs, files int) string {
+	tb.Helper()
+
+	root := tb.TempDir()
+	for d := 0; d < dirs; d++ {
+		dir := filepath.Join(root, fmt.Sprintf("d%02d", d%10), fmt.Sprintf("d%04d", d))
+		os.MkdirAll(dir, 0755)
+		for f := 0; f < files; f++ {
+			os.WriteFile(filepath.Join(dir, fmt.Sprintf("f%04d", f)), nil, 0644)
+		}
+		if d%10 == 0 {
+			sdfp, _ := GetSdFile(filepath.Join(dir, "f0000"))
+			writeTestSdFile(tb, sdfp, SdRecord{Name: "f0000", Action: Delete, MarkedAt: time.Now()})
+		}
+	}
+	return root
+}
+
+func TestFindSdFolders(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, sub := range []string{"a", filepath.Join("a", "b"), filepath.Join("c", "d")} {
+		os.MkdirAll(filepath.Join(dir, sub, SdFolderName), 0755)
+	}
+	os.MkdirAll(filepath.Join(dir, "e"), 0755)
+
+	sdFolders, err := findSdFolders(dir, 4, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		filepath.Join(dir, "a", SdFolderName),
+		filepath.Join(dir, "a", "b", SdFolderName),
+		filepath.Join(dir, "c", "d", SdFolderName),
+	}
+	if strings.Join(sdFolders, "\n") != strings.Join(expected, "\n") {
+		t.Error(fmt.Sprintf("found %v, expecting %v", sdFolders, expected))
+	}
+}
+
+// BenchmarkFindSdFoldersWalk is the filepath.Walk search that sweep used
+// to do, for comparison with BenchmarkFindSdFolders.
+func BenchmarkFindSdFoldersWalk(b *testing.B) {
+	root := makeSyntheticTree(b, 500, 100)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		sdFolders := make([]string, 0)
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() && info.Name() == SdFolderName {
+				sdFolders = append(sdFolders, path)
+			}
+			return nil
+		})
+	}
+}
+
+func BenchmarkFindSdFolders(b *testing.B) {
+	root := makeSyntheticTree(b, 500, 100)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		findSdFolders(root, defaultScanWorkers, nil)
+	}
+}
+
+// BenchmarkWalkSdFoldersPostOrderSerial reads one folder at a time, as
+// the streaming sweep used to, for comparison with
+// BenchmarkWalkSdFoldersPostOrder.
+func BenchmarkWalkSdFoldersPostOrderSerial(b *testing.B) {
+	root := makeSyntheticTree(b, 500, 100)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		walkSdFoldersPostOrder(root, nil, 0, func(string) error { return nil })
+	}
+}
+
+func BenchmarkWalkSdFoldersPostOrder(b *testing.B) {
+	root := makeSyntheticTree(b, 500, 100)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		walkSdFoldersPostOrder(root, nil, defaultScanWorkers, func(string) error { return nil })
+	}
+}
+
+func BenchmarkPlanSweep(b *testing.B) {
+	root := makeSyntheticTree(b, 500, 100)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		PlanSweep(root, SweepOptions{ExpiryMonths: 12})
+	}
+}
+
+func TestSweepDirectoryExclude(t *testing.T) {
+	dir := t.TempDir()
+
+	cacheDir := filepath.Join(dir, "cache")
+	os.Mkdir(cacheDir, 0755)
+	deletedFp := filepath.Join(dir, "deleted.txt")
+	tmpFp := filepath.Join(dir, "scratch.tmp")
+	cachedFp := filepath.Join(cacheDir, "cached.txt")
+	for _, fp := range []string{deletedFp, tmpFp, cachedFp} {
+		f, _ := os.Create(fp)
+		f.Close()
+		SetActionForFile(fp, Delete)
+	}
+
+	opts := SweepOptions{ExpiryMonths: 12, Exclude: []string{"cache", "*.tmp"}}
+	plan, err := PlanSweep(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range plan.Entries {
+		if entry.Path == tmpFp && entry.Kind != Excluded {
+			t.Error(fmt.Sprintf("'%s' planned as %v, expecting %v", entry.Path, entry.Kind, Excluded))
+		}
+		if isWithin(entry.Path, cacheDir) {
+			t.Error(fmt.Sprintf("'%s' planned in an excluded folder", entry.Path))
+		}
+	}
+
+	if err := SweepDirectory(dir, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(deletedFp); !os.IsNotExist(err) {
+		t.Error("Marked file wasn't deleted")
+	}
+	for _, fp := range []string{tmpFp, cachedFp} {
+		if _, err := os.Stat(fp); err != nil {
+			t.Error(fmt.Sprintf("Excluded file '%s' was deleted", fp))
+		}
+	}
+}
+
+func TestSweepDirectoryDeletesChildrenBeforeParents(t *testing.T) {
+	dir := t.TempDir()
+
+	parent := filepath.Join(dir, "parent")
+	child := filepath.Join(parent, "child.txt")
+	os.Mkdir(parent, 0755)
+	os.WriteFile(child, []byte("test\n"), 0644)
+	SetActionForFile(parent, Delete)
+	SetActionForFile(child, Delete)
+
+	var out strings.Builder
+	opts := SweepOptions{ExpiryMonths: 12, QueueSize: 1, Logger: newTestLogger(&out)}
+	err := SweepDirectory(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	childAt := findLogRecord(out.String(), "Deleting", TargetKey+"="+child)
+	parentAt := findLogRecord(out.String(), "Deleting", TargetKey+"="+parent)
+	if childAt < 0 || parentAt < 0 || childAt > parentAt {
+		t.Error(fmt.Sprintf("expecting '%s' to be deleted before '%s':\n%s", child, parent, out.String()))
+	}
+
+	if _, err := os.Stat(parent); !os.IsNotExist(err) {
+		t.Error(fmt.Sprintf("'%s' was not removed by the sweep", parent))
+	}
+}
+
+func TestSweepDirectoryLocks(t *testing.T) {
+	dir := t.TempDir()
+
+	tfp := filepath.Join(dir, "test.txt")
+	os.WriteFile(tfp, []byte("test\n"), 0644)
+	SetActionForFile(tfp, Delete)
+
+	hostname, _ := os.Hostname()
+	lockFile := filepath.Join(dir, LockFileName)
+	writeLock := func(pid int, host string, started time.Time) {
+		os.WriteFile(lockFile, []byte(fmt.Sprintf("pid: %d\nhost: %s\nstarted: %s\n",
+			pid, host, started.UTC().Format(time.RFC3339))), 0644)
+	}
+
+	// This process holds the lock.
+	writeLock(os.Getpid(), hostname, time.Now())
+	err := SweepDirectory(dir, SweepOptions{ExpiryMonths: 12})
+	var held *LockHeldError
+	if !errors.As(err, &held) {
+		t.Fatal(fmt.Sprintf("expecting a LockHeldError, got %v", err))
+	}
+	if _, err := os.Stat(tfp); err != nil {
+		t.Error("file deleted while the sweep was locked")
+	}
+
+	// An external lock is present.
+	os.Remove(lockFile)
+	externalLock := filepath.Join(t.TempDir(), "sync.lock")
+	os.WriteFile(externalLock, nil, 0644)
+	opts := SweepOptions{ExpiryMonths: 12, ExternalLocks: []string{externalLock}}
+	err = SweepDirectory(dir, opts)
+	if !errors.As(err, &held) || held.LockFile != externalLock {
+		t.Fatal(fmt.Sprintf("expecting a LockHeldError for '%s', got %v", externalLock, err))
+	}
+
+	// A stale lock copied from another host is ignored.
+	os.Remove(externalLock)
+	writeLock(1, "another-host", time.Now().Add(-2*DefaultStaleLockAge))
+	err = SweepDirectory(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(tfp); !os.IsNotExist(err) {
+		t.Error(fmt.Sprintf("'%s' was not removed by the sweep", tfp))
+	}
+	if _, err := os.Stat(lockFile); !os.IsNotExist(err) {
+		t.Error("the lock was not released")
+	}
+}
+
+func TestSweepDirectoryNestedLocks(t *testing.T) {
+	hostname, _ := os.Hostname()
+	holdLock := func(dir string) {
+		f, _ := os.Create(filepath.Join(dir, LockFileName))
+		writeLockInfo(f, hostname)
+		f.Close()
+	}
+
+	for _, opts := range []SweepOptions{
+		{ExpiryMonths: 12},
+		{ExpiryMonths: 12, Limits: DeletionLimits{MaxTargets: 10}},
+	} {
+		dir := t.TempDir()
+		sub := filepath.Join(dir, "sub")
+		os.Mkdir(sub, 0755)
+		tfp := filepath.Join(dir, "test.txt")
+		subFp := filepath.Join(sub, "test.txt")
+		for _, fp := range []string{tfp, subFp} {
+			os.WriteFile(fp, []byte("test\n"), 0644)
+			SetActionForFile(fp, Delete)
+		}
+
+		// A sweep of a folder within one being swept waits for it.
+		holdLock(dir)
+		err := SweepDirectory(sub, opts)
+		var held *LockHeldError
+		if !errors.As(err, &held) || held.LockFile != filepath.Join(dir, LockFileName) {
+			t.Error(fmt.Sprintf("expecting a LockHeldError for '%s', got %v", dir, err))
+		}
+		if _, err := os.Stat(filepath.Join(sub, LockFileName)); !os.IsNotExist(err) {
+			t.Error("the nested sweep left its lock behind")
+		}
+		os.Remove(filepath.Join(dir, LockFileName))
+
+		// A sweep of a folder containing one being swept leaves it alone.
+		holdLock(sub)
+		if err := SweepDirectory(dir, opts); err != nil {
+			t.Error(err)
+		}
+		if _, err := os.Stat(tfp); !os.IsNotExist(err) {
+			t.Error(fmt.Sprintf("'%s' was not removed by the sweep", tfp))
+		}
+		if _, err := os.Stat(subFp); err != nil {
+			t.Error(fmt.Sprintf("'%s' was removed while another sweep held its folder", subFp))
+		}
+	}
+}
+
+func TestSweepDirectoryUnreadableLock(t *testing.T) {
+	dir := t.TempDir()
+	tfp := filepath.Join(dir, "test.txt")
+	os.WriteFile(tfp, []byte("test\n"), 0644)
+	SetActionForFile(tfp, Delete)
+
+	// A sweep that died before writing its lock left it empty.
+	lockFile := filepath.Join(dir, LockFileName)
+	os.WriteFile(lockFile, nil, 0644)
+	err := SweepDirectory(dir, SweepOptions{ExpiryMonths: 12})
+	var held *LockHeldError
+	if !errors.As(err, &held) {
+		t.Fatal(fmt.Sprintf("expecting a new empty lock to be held, got %v", err))
+	}
+
+	longAgo := time.Now().Add(-2 * unreadableLockAge)
+	os.Chtimes(lockFile, longAgo, longAgo)
+	if err := SweepDirectory(dir, SweepOptions{ExpiryMonths: 12}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(tfp); !os.IsNotExist(err) {
+		t.Error(fmt.Sprintf("'%s' was not removed past an old empty lock", tfp))
+	}
+}
+
+func TestRemoveStaleLockLeavesNewLock(t *testing.T) {
+	dir := t.TempDir()
+	lockFile := filepath.Join(dir, LockFileName)
+
+	// Another sweep took over the stale lock after this one read it.
+	stale := lockInfo{PID: 1, Host: "another-host", Started: time.Now().Add(-2 * DefaultStaleLockAge).Truncate(time.Second)}
+	hostname, _ := os.Hostname()
+	f, _ := os.Create(lockFile)
+	writeLockInfo(f, hostname)
+	f.Close()
+
+	err := removeStaleLock(dir, lockFile, &stale, hostname, SweepOptions{})
+	var held *LockHeldError
+	if !errors.As(err, &held) {
+		t.Error(fmt.Sprintf("expecting a LockHeldError, got %v", err))
+	}
+	holder, err := readLock(lockFile)
+	if err != nil || holder.PID != os.Getpid() {
+		t.Error(fmt.Sprintf("the new lock was removed along with the stale one: %v %v", holder, err))
+	}
+	if leftovers, _ := filepath.Glob(lockFile + ".*"); len(leftovers) != 0 {
+		t.Error(fmt.Sprintf("files were left behind: %v", leftovers))
+	}
+
+	// The stale lock itself is removed.
+	os.Remove(lockFile)
+	f, _ = os.Create(lockFile)
+	fmt.Fprintf(f, "pid: %d\nhost: %s\nstarted: %s\n", stale.PID, stale.Host, stale.Started.UTC().Format(time.RFC3339))
+	f.Close()
+	if err := removeStaleLock(dir, lockFile, &stale, hostname, SweepOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(lockFile); !os.IsNotExist(err) {
+		t.Error("the stale lock was not removed")
+	}
+}
+
+func TestSweepDirectoryContextStops(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, opts := range []SweepOptions{
+		{ExpiryMonths: 12},
+		{ExpiryMonths: 12, Limits: DeletionLimits{MaxTargets: 10}},
+	} {
+		dir := t.TempDir()
+		tfp := filepath.Join(dir, "test.txt")
+		os.WriteFile(tfp, []byte("test\n"), 0644)
+		SetActionForFile(tfp, Delete)
+
+		err := SweepDirectoryContext(ctx, dir, opts)
+		if !errors.Is(err, context.Canceled) {
+			t.Error(fmt.Sprintf("Expecting the sweep to stop, got %v", err))
+		}
+		if _, err := os.Stat(tfp); err != nil {
+			t.Error(fmt.Sprintf("'%s' was deleted by a stopped sweep", tfp))
+		}
+		if _, err := os.Stat(filepath.Join(dir, LockFileName)); !os.IsNotExist(err) {
+			t.Error("the lock was not released")
+		}
+	}
+}
+
+func TestWatchMarksDeletedFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	subDir := filepath.Join(dir, "sub")
+	os.Mkdir(subDir, 0755)
+	tfp := filepath.Join(subDir, "test.txt")
+	os.WriteFile(tfp, []byte("test\n"), 0644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	watching := make(chan error)
+	go func() {
+		watching <- Watch(ctx, dir, WatchOptions{Debounce: 100 * time.Millisecond})
+	}()
+
+	// Give the watcher time to add the tree.
+	time.Sleep(200 * time.Millisecond)
+	os.Remove(tfp)
+
+	sdfp, _ := GetSdFile(tfp)
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(sdfp); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-watching; err != nil {
+		t.Error(err)
+	}
+
+	gotAction, err := GetActionForFile(sdfp, subDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotAction.Action != Delete {
+		t.Error(fmt.Sprintf("deleted file marked %v", gotAction.Action))
+	}
+}
+
+func TestWatchShortDebounce(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if err := Watch(ctx, t.TempDir(), WatchOptions{Debounce: time.Nanosecond}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	from := time.Date(2026, 10, 17, 10, 20, 30, 0, time.UTC)
+	cases := []struct {
+		schedule string
+		next     time.Time
+	}{
+		{"6h", from.Add(6 * time.Hour)},
+		{"@every 90m", from.Add(90 * time.Minute)},
+		{"@hourly", time.Date(2026, 10, 17, 11, 0, 0, 0, time.UTC)},
+		{"30 3 * * *", time.Date(2026, 10, 18, 3, 30, 0, 0, time.UTC)},
+		{"*/15 10 * * *", time.Date(2026, 10, 17, 10, 30, 0, 0, time.UTC)},
+		{"0 0 * * 1-5", time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 1 *", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, c := range cases {
+		schedule, err := ParseSchedule(c.schedule)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		if next := schedule.Next(from); !next.Equal(c.next) {
+			t.Error(fmt.Sprintf("'%s' next runs at %v, expecting %v", c.schedule, next, c.next))
+		}
+	}
+
+	for _, bad := range []string{"", "every day", "60 * * * *", "* * * *", "-1h"} {
+		if _, err := ParseSchedule(bad); err == nil {
+			t.Error(fmt.Sprintf("'%s' parsed as a schedule", bad))
+		}
+	}
+}
+
+// daemonTestReporter keeps the root reports of a daemon's sweeps, calling
+// onEntries with each batch of entries.
+type daemonTestReporter struct {
+	mu        sync.Mutex
+	onEntries func()
+	roots     []RootReport
+}
+
+func (r *daemonTestReporter) ReportEntries(root string, entries []ReportEntry) error {
+	r.onEntries()
+	return nil
+}
+
+func (r *daemonTestReporter) ReportRoot(root RootReport) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.roots = append(r.roots, root)
+	return nil
+}
+
+func (r *daemonTestReporter) Close() error {
+	return nil
+}
+
+// runTestDaemon runs RunDaemon on root every millisecond until ctx is done
+// and waits up to five seconds for it to return.
+func runTestDaemon(t *testing.T, ctx context.Context, root string, reporter Reporter) {
+	t.Helper()
+
+	target := DaemonTarget{Name: "test", Path: root, Schedule: intervalSchedule(time.Millisecond),
+		Options: SweepOptions{ExpiryMonths: 12, Reporter: reporter}}
+	done := make(chan error)
+	go func() {
+		done <- RunDaemon(ctx, []DaemonTarget{target}, nil)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the daemon didn't stop when its context was done")
+	}
+}
+
+func TestRunDaemonStopsSweepInProgress(t *testing.T) {
+	root := makeSyntheticTree(t, 200, 1)
+
+	// Stop the daemon once the first sweep has deleted something.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reporter := &daemonTestReporter{onEntries: cancel}
+	runTestDaemon(t, ctx, root, reporter)
+
+	if len(reporter.roots) != 1 || len(reporter.roots[0].Errors) != 1 ||
+		!strings.Contains(reporter.roots[0].Errors[0].Error, context.Canceled.Error()) {
+		t.Error(fmt.Sprintf("expecting one sweep stopped by the daemon, got %v", reporter.roots))
+	}
+
+	remaining := 0
+	for d := 0; d < 200; d += 10 {
+		tfp := filepath.Join(root, fmt.Sprintf("d%02d", d%10), fmt.Sprintf("d%04d", d), "f0000")
+		if _, err := os.Stat(tfp); err == nil {
+			remaining++
+		}
+	}
+	if remaining == 0 {
+		t.Error("the sweep carried on after the daemon stopped")
+	}
+}
+
+func TestRunDaemonRunsDontOverlap(t *testing.T) {
+	root := t.TempDir()
+	tfp := filepath.Join(root, "test.txt")
+	os.WriteFile(tfp, []byte("test\n"), 0644)
+	SetActionForFile(tfp, Delete)
+
+	// Each sweep takes much longer than the interval between sweeps. One
+	// that overlapped another would find the root locked.
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	reporter := &daemonTestReporter{onEntries: func() { time.Sleep(50 * time.Millisecond) }}
+	runTestDaemon(t, ctx, root, reporter)
+
+	if len(reporter.roots) < 2 {
+		t.Error(fmt.Sprintf("expecting the target to be swept repeatedly, got %d sweeps", len(reporter.roots)))
+	}
+	for _, report := range reporter.roots {
+		for _, reportErr := range report.Errors {
+			if strings.Contains(reportErr.Error, LockFileName) {
+				t.Error(fmt.Sprintf("a sweep overlapped another: %s", reportErr.Error))
+			}
+		}
+	}
+}
+
+func TestGetWritersLeavesOtherRunsLogs(t *testing.T) {
+	logsDir := t.TempDir()
+	retention := LogRetention{MaxCount: 1, Compress: true}
+
+	// A daemon's log, made before a sweep from cron starts and prunes.
+	daemonOut, _, closeDaemonLogs, err := GetWriters(logsDir, retention)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(daemonOut, "daemon\n")
+	time.Sleep(time.Second)
+
+	_, _, closeSweepLogs, err := GetWriters(logsDir, retention)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(daemonOut, "still the daemon\n")
+	closeSweepLogs()
+	closeDaemonLogs()
+
+	sdLogFolder := filepath.Join(logsDir, "staydeleted")
+	logs, _ := filepath.Glob(filepath.Join(sdLogFolder, "*.log"))
+	found := false
+	for _, log := range logs {
+		contents, _ := os.ReadFile(log)
+		found = found || string(contents) == "daemon\nstill the daemon\n"
+	}
+	if !found {
+		t.Error(fmt.Sprintf("the daemon's log was pruned by another run, leaving %v", logs))
+	}
+
+	holds, _ := filepath.Glob(filepath.Join(sdLogFolder, ".*.held"))
+	if len(holds) != 0 {
+		t.Error(fmt.Sprintf("hold files were left behind: %v", holds))
+	}
+}
+
+func TestGetWritersRetention(t *testing.T) {
+	logsDir := t.TempDir()
+	sdLogFolder := filepath.Join(logsDir, "staydeleted")
+	os.Mkdir(sdLogFolder, 0755)
+
+	oldLog := filepath.Join(sdLogFolder, "2020-01-01_00.00.00.log")
+	recentLog := filepath.Join(sdLogFolder, "2020-01-02_00.00.00.log")
+	emptyErr := filepath.Join(sdLogFolder, "2020-01-02_00.00.00.err")
+	os.WriteFile(oldLog, []byte("old\n"), 0644)
+	os.WriteFile(recentLog, []byte("recent\n"), 0644)
+	os.WriteFile(emptyErr, nil, 0644)
+	longAgo := time.Now().AddDate(0, 0, -100)
+	os.Chtimes(oldLog, longAgo, longAgo)
+
+	retention := LogRetention{MaxAge: 30 * 24 * time.Hour, MaxSize: 10, Compress: true}
+	outWriter, _, closeLogs, err := GetWriters(logsDir, retention)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(outWriter, "first line\n")
+	fmt.Fprintf(outWriter, "second line\n")
+	if err := closeLogs(); err != nil {
+		t.Error(err)
+	}
+
+	for _, fp := range []string{oldLog, recentLog, emptyErr} {
+		if _, err := os.Stat(fp); !os.IsNotExist(err) {
+			t.Error(fmt.Sprintf("'%s' was not tidied away", fp))
+		}
+	}
+	if _, err := os.Stat(recentLog + ".gz"); err != nil {
+		t.Error(fmt.Sprintf("'%s' was not compressed: %v", recentLog, err))
+	}
+
+	errLogs, _ := filepath.Glob(filepath.Join(sdLogFolder, "*.err"))
+	if len(errLogs) != 0 {
+		t.Error(fmt.Sprintf("empty error logs were made: %v", errLogs))
+	}
+
+	// The first log was rotated out and compressed when it grew too big.
+	runLogs, _ := filepath.Glob(filepath.Join(sdLogFolder, time.Now().Format("2006")+"-*"))
+	if len(runLogs) != 2 {
+		t.Error(fmt.Sprintf("expecting the run's log to be rotated once, got %v", runLogs))
+	}
+	latest, err := os.ReadFile(filepath.Join(sdLogFolder, LatestLogName))
+	if runtime.GOOS != "windows" && string(latest) != "second line\n" {
+		t.Error(fmt.Sprintf("latest.log doesn't point at the newest log: %q %v", latest, err))
+	}
+}
@@ -0,0 +1,298 @@
+package sdlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// defaultQueueSize is used when SweepOptions.QueueSize isn't set.
+const defaultQueueSize = 64
+
+// checkRoot makes sure root is a directory and returns its absolute path.
+func checkRoot(root string) (string, error) {
+	stat, err := os.Stat(root)
+	if err != nil {
+		return "", err
+	}
+
+	if !stat.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", root)
+	}
+
+	return filepath.Abs(root)
+}
+
+// walkSdFoldersPostOrder calls fn with each SD folder under dir, visiting
+// the SD folders in a directory's subfolders before the directory's own,
+// so that files inside a marked folder are dealt with before the folder.
+// Subfolders are read ahead of the walk, up to workers at a time.
+func walkSdFoldersPostOrder(dir string, exclude []string, workers int, fn func(sdFolder string) error) error {
+	w := &postOrderWalker{exclude: exclude, slots: make(chan struct{}, workers), fn: fn}
+	return w.walk(dir, &dirScan{})
+}
+
+// postOrderWalker walks a tree in post-order. As it reaches each folder it
+// starts reading the folder's subfolders in the background, so that the
+// walk isn't held up by the disk one folder at a time. A read ahead holds
+// a slot until the walk gets to it, so no more than len(slots) folders'
+// contents are held in memory ahead of the walk.
+type postOrderWalker struct {
+	exclude []string
+	slots   chan struct{}
+	fn      func(sdFolder string) error
+}
+
+// dirScan is the result of reading a folder. If done is nil, the folder
+// wasn't read ahead and is read when the walk reaches it.
+type dirScan struct {
+	done      chan struct{}
+	subdirs   []string
+	sdFolders []string
+	err       error
+}
+
+func (w *postOrderWalker) readAhead(dir string) *dirScan {
+	scan := &dirScan{}
+	select {
+	case w.slots <- struct{}{}:
+		scan.done = make(chan struct{})
+		go func() {
+			scan.subdirs, scan.sdFolders, scan.err = scanDir(dir, w.exclude)
+			close(scan.done)
+		}()
+	default:
+	}
+	return scan
+}
+
+// wait fills in scan, reading dir now if it wasn't read ahead.
+func (w *postOrderWalker) wait(dir string, scan *dirScan) {
+	if scan.done == nil {
+		scan.subdirs, scan.sdFolders, scan.err = scanDir(dir, w.exclude)
+		return
+	}
+	<-scan.done
+	<-w.slots
+}
+
+func (w *postOrderWalker) walk(dir string, scan *dirScan) error {
+	w.wait(dir, scan)
+	if scan.err != nil {
+		return scan.err
+	}
+
+	subScans := make([]*dirScan, len(scan.subdirs))
+	for i, subdir := range scan.subdirs {
+		subScans[i] = w.readAhead(subdir)
+	}
+	for i, subdir := range scan.subdirs {
+		if err := w.walk(subdir, subScans[i]); err != nil {
+			// Give back the slots of the read aheads that won't be walked.
+			for j := i + 1; j < len(subScans); j++ {
+				if subScans[j].done != nil {
+					w.wait(scan.subdirs[j], subScans[j])
+				}
+			}
+			return err
+		}
+	}
+
+	for _, sdFolder := range scan.sdFolders {
+		if err := w.fn(sdFolder); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sweepStream sweeps root as a pipeline. SD folders are discovered by a
+// walk that reads folders ahead of itself, classified by a pool of workers
+// and executed in the order they were discovered as soon as they are
+// classified. At most opts.QueueSize SD folders are in the pipeline at
+// once, so memory use doesn't grow with the size of the tree, and
+// deletions start before the walk finishes. When ctx is done, the sweep
+// stops before the next SD folder.
+func sweepStream(ctx context.Context, root string, opts SweepOptions, report *RootReport) error {
+	absRoot, err := checkRoot(root)
+	if err != nil {
+		return err
+	}
+
+	planner := newPlanner(absRoot, opts)
+	locks := newNestedLocks(absRoot, opts)
+
+	queueSize := opts.QueueSize
+	if queueSize < 1 {
+		queueSize = defaultQueueSize
+	}
+
+	type job struct {
+		seq      int
+		sdFolder string
+	}
+	type result struct {
+		seq     int
+		entries []PlanEntry
+		err     error
+	}
+
+	slots := make(chan struct{}, queueSize)
+	jobs := make(chan job)
+	results := make(chan result)
+	done := make(chan struct{})
+
+	var walkErr error
+	go func() {
+		defer close(jobs)
+
+		seq := 0
+		walkErr = walkSdFoldersPostOrder(absRoot, opts.Exclude, scanWorkers(opts), func(sdFolder string) error {
+			select {
+			case <-done:
+				return errPipelineStopped
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+
+			// Folders another sweep holds the lock of are left to it.
+			if locks.heldFor(sdFolder) != nil {
+				return nil
+			}
+
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return errPipelineStopped
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			jobs <- job{seq, sdFolder}
+			seq++
+			return nil
+		})
+	}()
+
+	var workers sync.WaitGroup
+	for w := 0; w < scanWorkers(opts); w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for j := range jobs {
+				entries, err := planner.planSdFolder(j.sdFolder)
+				results <- result{j.seq, entries, err}
+			}
+		}()
+	}
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	// Results can arrive out of order, so they are held until all the
+	// earlier SD folders have been executed. There are never more than
+	// queueSize of them held.
+	pending := make(map[int]result)
+	next := 0
+	var pipelineErr error
+	var failures []PathFailure
+	for r := range results {
+		pending[r.seq] = r
+		for {
+			ready, found := pending[next]
+			if !found {
+				break
+			}
+			delete(pending, next)
+			next++
+
+			if pipelineErr == nil {
+				pipelineErr = ready.err
+				if pipelineErr == nil {
+					pipelineErr = ctx.Err()
+				}
+				if pipelineErr != nil {
+					close(done)
+				}
+			}
+
+			if pipelineErr == nil {
+				plan := &SweepPlan{Roots: []string{absRoot}, Entries: ready.entries}
+				logPlan(plan, opts.Logger)
+				executed, err := executePlan(ctx, plan, opts)
+				report.addEntries(executed)
+				var partial *PartialFailureError
+				if errors.As(err, &partial) {
+					failures = append(failures, partial.Failures...)
+				} else if err != nil {
+					pipelineErr = err
+					close(done)
+				}
+			}
+
+			<-slots
+		}
+	}
+
+	if pipelineErr != nil {
+		return pipelineErr
+	}
+	if walkErr != nil {
+		return walkErr
+	}
+	if len(failures) > 0 {
+		return &PartialFailureError{Root: absRoot, Failures: failures}
+	}
+	return nil
+}
+
+var errPipelineStopped = errors.New("sweep stopped")
+
+// sweepPlanned plans the whole of root before deleting anything, so that
+// the plan can be checked against opts.Limits and reviewed by opts.Review.
+// If the plan breaks a limit, it is logged and nothing is deleted. When ctx
+// is done, the sweep stops before the next deletion.
+func sweepPlanned(ctx context.Context, root string, opts SweepOptions, report *RootReport) error {
+	plan, err := PlanSweep(root, opts)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	absRoot := plan.Roots[0]
+
+	logPlan(plan, opts.Logger)
+	if err := checkLimits(plan, absRoot, opts.Limits, opts.Exclude); err != nil {
+		return err
+	}
+	if opts.Review != nil {
+		reviewPlan(plan, opts.Review, opts.Logger)
+	}
+
+	executed, err := executePlan(ctx, plan, opts)
+	report.addEntries(executed)
+	if partial, ok := err.(*PartialFailureError); ok {
+		partial.Root = absRoot
+	}
+	return err
+}
+
+// reviewPlan asks review about each target plan would delete, marking the
+// ones it doesn't approve as skipped.
+func reviewPlan(plan *SweepPlan, review func(entry PlanEntry) bool, logger *slog.Logger) {
+	for i, entry := range plan.Entries {
+		if entry.Kind != TargetDeletion || review(entry) {
+			continue
+		}
+
+		plan.Entries[i].Kind = Skipped
+		logPlan(&SweepPlan{Entries: plan.Entries[i : i+1]}, logger)
+	}
+}
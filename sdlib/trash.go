@@ -0,0 +1,142 @@
+package sdlib
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DeleteMode is how a sweep gets rid of targets marked for deletion.
+type DeleteMode int
+
+const (
+	// RemoveMode removes targets permanently.
+	RemoveMode DeleteMode = iota
+	// TrashMode moves targets to the freedesktop.org trash so they can be restored.
+	TrashMode
+)
+
+func (m DeleteMode) String() string {
+	if m == TrashMode {
+		return "trash"
+	}
+	return "remove"
+}
+
+// ParseDeleteMode converts "remove" or "trash" to a DeleteMode.
+func ParseDeleteMode(modeStr string) (DeleteMode, error) {
+	switch modeStr {
+	case "", "remove":
+		return RemoveMode, nil
+	case "trash":
+		return TrashMode, nil
+	}
+	return RemoveMode, fmt.Errorf("unable to convert %s to a delete mode", modeStr)
+}
+
+// removeTarget gets rid of path in the given mode.
+func removeTarget(path string, mode DeleteMode) error {
+	if mode == TrashMode {
+		return MoveToTrash(path)
+	}
+	return os.RemoveAll(path)
+}
+
+// MoveToTrash moves path to the trash following the freedesktop.org Trash
+// specification, writing a .trashinfo file with the original path and the
+// deletion date so that desktop tools can restore it.
+func MoveToTrash(path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	trashDir, topDir, err := trashDirFor(absPath)
+	if err != nil {
+		return err
+	}
+
+	filesDir := filepath.Join(trashDir, "files")
+	infoDir := filepath.Join(trashDir, "info")
+	for _, dir := range []string{filesDir, infoDir} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+
+	originalPath := absPath
+	if len(topDir) > 0 {
+		originalPath, err = filepath.Rel(topDir, absPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	infoFile, trashName, err := createTrashInfo(infoDir, filepath.Base(absPath))
+	if err != nil {
+		return err
+	}
+	infoFileName := infoFile.Name()
+
+	_, err = fmt.Fprintf(infoFile, "[Trash Info]\nPath=%s\nDeletionDate=%s\n",
+		escapeTrashPath(originalPath), time.Now().Format("2006-01-02T15:04:05"))
+	if closeErr := infoFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(infoFileName)
+		return err
+	}
+
+	err = os.Rename(absPath, filepath.Join(filesDir, trashName))
+	if err != nil {
+		os.Remove(infoFileName)
+		return err
+	}
+
+	return nil
+}
+
+// createTrashInfo atomically claims a name in the trash that isn't already
+// used by another trashed file.
+func createTrashInfo(infoDir, baseName string) (*os.File, string, error) {
+	for i := 1; ; i++ {
+		trashName := baseName
+		if i > 1 {
+			ext := filepath.Ext(baseName)
+			trashName = fmt.Sprintf("%s.%d%s", strings.TrimSuffix(baseName, ext), i, ext)
+		}
+
+		infoFile, err := os.OpenFile(filepath.Join(infoDir, trashName+".trashinfo"),
+			os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+
+		return infoFile, trashName, nil
+	}
+}
+
+func escapeTrashPath(path string) string {
+	return (&url.URL{Path: filepath.ToSlash(path)}).EscapedPath()
+}
+
+// homeTrashDir is $XDG_DATA_HOME/Trash, defaulting to ~/.local/share/Trash.
+func homeTrashDir() (string, error) {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if len(dataHome) == 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dataHome, "Trash"), nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package sdlib
+
+import "errors"
+
+func trashDirFor(absPath string) (string, string, error) {
+	return "", "", errors.New("moving to the trash is not supported on this platform")
+}
@@ -0,0 +1,79 @@
+//go:build unix
+
+package sdlib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// trashDirFor picks the trash that absPath can be renamed into: the home
+// trash if it is on the same device, otherwise the trash at the top of
+// absPath's volume. topDir is set when a volume trash is used, as paths in
+// its .trashinfo files are relative to the top of the volume.
+func trashDirFor(absPath string) (trashDir string, topDir string, err error) {
+	pathDev, err := deviceOf(filepath.Dir(absPath))
+	if err != nil {
+		return "", "", err
+	}
+
+	homeTrash, err := homeTrashDir()
+	if err != nil {
+		return "", "", err
+	}
+
+	if homeDev, err := deviceOf(existingAncestor(homeTrash)); err == nil && homeDev == pathDev {
+		return homeTrash, "", nil
+	}
+
+	topDir = filepath.Dir(absPath)
+	for {
+		parent := filepath.Dir(topDir)
+		if parent == topDir {
+			break
+		}
+		parentDev, err := deviceOf(parent)
+		if err != nil || parentDev != pathDev {
+			break
+		}
+		topDir = parent
+	}
+
+	uid := os.Getuid()
+
+	// An administrator-provided $topdir/.Trash must be a real, sticky directory.
+	adminTrash := filepath.Join(topDir, ".Trash")
+	if info, err := os.Lstat(adminTrash); err == nil && info.IsDir() && info.Mode()&os.ModeSticky != 0 {
+		return filepath.Join(adminTrash, fmt.Sprint(uid)), topDir, nil
+	}
+
+	return filepath.Join(topDir, fmt.Sprintf(".Trash-%d", uid)), topDir, nil
+}
+
+func deviceOf(path string) (uint64, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return 0, err
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("unable to find the device of '%s'", path)
+	}
+	return uint64(stat.Dev), nil
+}
+
+func existingAncestor(path string) string {
+	for {
+		if _, err := os.Lstat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
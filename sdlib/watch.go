@@ -0,0 +1,187 @@
+package sdlib
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// DefaultWatchDebounce is how long Watch waits after a file vanishes
+// before marking it.
+const DefaultWatchDebounce = 2 * time.Second
+
+// minWatchTick is the shortest interval at which Watch checks for vanished
+// files that have settled, however short the debounce.
+const minWatchTick = 10 * time.Millisecond
+
+// WatchOptions controls how Watch behaves.
+type WatchOptions struct {
+	// Debounce is how long a vanished file must stay gone before it is
+	// marked. Editors that save by replacing a file, and folders being
+	// removed a file at a time, settle within this time. Zero uses
+	// DefaultWatchDebounce.
+	Debounce time.Duration
+	// Logger receives a record of each file marked. Nil uses slog's default.
+	Logger *slog.Logger
+}
+
+// Watch marks files for deletion as they are removed from, or renamed out
+// of, the tree under root, until ctx is done. Changes inside SD folders
+// are ignored, as are files removed by a sweep, which holds the sweep
+// lock while it runs, and files already marked for deletion.
+func Watch(ctx context.Context, root string, opts WatchOptions) error {
+	absRoot, err := checkRoot(root)
+	if err != nil {
+		return err
+	}
+	logger := loggerOrDefault(opts.Logger).With(RootKey, absRoot)
+
+	debounce := opts.Debounce
+	if debounce <= 0 {
+		debounce = DefaultWatchDebounce
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	if err := watchTree(watcher, absRoot, logger); err != nil {
+		return err
+	}
+	logger.Info("Watching")
+
+	vanished := make(map[string]time.Time)
+
+	ticker := time.NewTicker(max(debounce/4, minWatchTick))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if inSdFolder(event.Name) || filepath.Base(event.Name) == LockFileName {
+				continue
+			}
+
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Lstat(event.Name); err == nil && info.IsDir() {
+					if err := watchTree(watcher, event.Name, logger); err != nil {
+						logger.Error("Unable to watch", "dir", event.Name, ErrorKey, err)
+					}
+				}
+			}
+
+			// The sweep lock is checked as the event arrives, as the sweep
+			// may have finished by the time the file is marked.
+			if (event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename)) && !sweepInProgress(event.Name) {
+				vanished[event.Name] = time.Now()
+			}
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			logger.Error("Watcher failed", ErrorKey, err)
+
+		case now := <-ticker.C:
+			for path, at := range vanished {
+				if now.Sub(at) >= debounce {
+					delete(vanished, path)
+					markVanished(path, logger)
+				}
+			}
+		}
+	}
+}
+
+// watchTree adds dir and every folder below it, other than SD folders, to
+// the watcher.
+func watchTree(watcher *fsnotify.Watcher, dir string, logger *slog.Logger) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			logger.Warn("Unable to watch", "dir", path, ErrorKey, err)
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if d.Name() == SdFolderName {
+			return filepath.SkipDir
+		}
+		return watcher.Add(path)
+	})
+}
+
+// markVanished marks path for deletion if it is still gone and nothing
+// else explains its disappearance.
+func markVanished(path string, logger *slog.Logger) {
+	if _, err := os.Lstat(path); err == nil {
+		// Replaced, as editors do when saving, or moved back.
+		return
+	}
+
+	if _, err := os.Stat(filepath.Dir(path)); err != nil {
+		// The whole folder went, and it will be marked itself if it was
+		// inside the tree. Marking inside it would recreate it.
+		return
+	}
+
+	if sweepInProgress(path) {
+		return
+	}
+
+	sdFile, err := GetSdFile(path)
+	if err != nil {
+		logger.Error("Unable to mark deleted file", TargetKey, path, ErrorKey, err)
+		return
+	}
+	if mark, err := GetActionForFile(sdFile, filepath.Dir(path)); err == nil && mark.Action == Delete {
+		return
+	}
+
+	logger.Info("File was deleted", TargetKey, path)
+	if err := MarkFile(path, Delete, MarkOptions{Logger: logger}); err != nil {
+		logger.Error("Unable to mark deleted file", TargetKey, path, ErrorKey, err)
+	}
+}
+
+// sweepInProgress reports whether a sweep holds the lock in any folder
+// containing path, up to the top of the file system.
+func sweepInProgress(path string) bool {
+	for dir := filepath.Dir(path); ; {
+		if _, err := os.Stat(filepath.Join(dir, LockFileName)); err == nil {
+			return true
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false
+		}
+		dir = parent
+	}
+}
+
+func inSdFolder(path string) bool {
+	for _, part := range strings.Split(filepath.ToSlash(path), "/") {
+		if part == SdFolderName {
+			return true
+		}
+	}
+	return false
+}